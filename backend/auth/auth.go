@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// トークンの有効期限
+const tokenTTL = 24 * time.Hour
+
+// JWTの秘密鍵（環境変数 JWT_SECRET があればそちらを使う）
+var secret = func() []byte {
+	if s := os.Getenv("JWT_SECRET"); s != "" {
+		return []byte(s)
+	}
+	return []byte("your-secret-key")
+}()
+
+var (
+	ErrMissingToken = errors.New("missing token")
+	ErrInvalidToken = errors.New("invalid token")
+)
+
+// context に入れるキーの型（他パッケージのキーと衝突しないように非公開）
+type contextKey struct{}
+
+var userIDKey = contextKey{}
+
+// ユーザーIDを subject に入れたトークンを発行
+func GenerateToken(userID int) (string, error) {
+	claims := jwt.RegisteredClaims{
+		Subject:   strconv.Itoa(userID),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(tokenTTL)),
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(secret)
+}
+
+// トークンを検証してユーザーIDを取り出す
+func ParseToken(tokenStr string) (int, error) {
+	claims := jwt.RegisteredClaims{}
+	token, err := jwt.ParseWithClaims(tokenStr, &claims, func(token *jwt.Token) (interface{}, error) {
+		return secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || !token.Valid {
+		return 0, ErrInvalidToken
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return 0, ErrInvalidToken
+	}
+	return userID, nil
+}
+
+// リクエストからトークン文字列を取得
+// Authorization: Bearer ヘッダー。allowQuery なら ?token= も見る（ヘッダーを付けられない WebSocket 用）
+func tokenFromRequest(r *http.Request, allowQuery bool) (string, error) {
+	if authHeader := r.Header.Get("Authorization"); authHeader != "" {
+		if !strings.HasPrefix(authHeader, "Bearer ") {
+			return "", ErrMissingToken
+		}
+		return strings.TrimPrefix(authHeader, "Bearer "), nil
+	}
+	if t := r.URL.Query().Get("token"); allowQuery && t != "" {
+		return t, nil
+	}
+	return "", ErrMissingToken
+}
+
+// 認証ミドルウェア：トークンを検証し、ユーザーIDを context に入れて次へ渡す
+// URL にトークンを載せるとログや Referer に残るため、?token= は受け付けない
+func Middleware(next http.Handler) http.Handler {
+	return authenticate(next, false)
+}
+
+// WebSocket 用の認証ミドルウェア（?token= も受け付ける）
+func WebSocketMiddleware(next http.Handler) http.Handler {
+	return authenticate(next, true)
+}
+
+func authenticate(next http.Handler, allowQuery bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenStr, err := tokenFromRequest(r, allowQuery)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		userID, err := ParseToken(tokenStr)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithUserID(r.Context(), userID)))
+	})
+}
+
+// http.HandlerFunc 用のショートカット（WithCORS と組み合わせて使う）
+func Require(next http.HandlerFunc) http.HandlerFunc {
+	return Middleware(next).ServeHTTP
+}
+
+// context にユーザーIDを入れる
+func WithUserID(ctx context.Context, userID int) context.Context {
+	return context.WithValue(ctx, userIDKey, userID)
+}
+
+// context からユーザーIDを取り出す
+func UserIDFromContext(ctx context.Context) (int, bool) {
+	userID, ok := ctx.Value(userIDKey).(int)
+	return userID, ok
+}
+
+// 認証済みリクエストのユーザーID（Middleware を通っていない場合は 0）
+func UserID(r *http.Request) int {
+	userID, _ := UserIDFromContext(r.Context())
+	return userID
+}
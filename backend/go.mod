@@ -5,9 +5,9 @@ go 1.23.0
 toolchain go1.24.3
 
 require (
-	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/golang-jwt/jwt/v5 v5.2.2
 	github.com/gorilla/websocket v1.5.3
 	github.com/lib/pq v1.10.9
 	golang.org/x/crypto v0.38.0
+	golang.org/x/image v0.25.0
 )
@@ -0,0 +1,211 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+)
+
+const (
+	uploadPrefix          = "uploads" // 保存先でのキーの接頭辞（URLの /uploads/ と同じ）
+	maxUploadSize         = 50 << 20  // 種類を問わない上限（リクエスト全体）
+	maxAttachmentsPerMsg  = 10
+	maxAttachmentFilename = 255
+)
+
+var errInvalidAttachment = errors.New("invalid attachment")
+
+// アップロードを受け付けるファイルの種類（中身から判定したMIME）ごとの拡張子と上限サイズ
+var attachmentTypes = map[string]struct {
+	ext     string
+	maxSize int64
+}{
+	"image/jpeg":      {".jpg", 10 << 20},
+	"image/png":       {".png", 10 << 20},
+	"image/gif":       {".gif", 10 << 20},
+	"image/webp":      {".webp", 10 << 20},
+	"video/mp4":       {".mp4", 50 << 20},
+	"audio/mpeg":      {".mp3", 20 << 20},
+	"application/pdf": {".pdf", 20 << 20},
+	"application/zip": {".zip", 20 << 20},
+	"text/plain":      {".txt", 1 << 20},
+}
+
+// メッセージに付いた添付ファイル
+type Attachment struct {
+	ID       int    `json:"id"`
+	URL      string `json:"url"`
+	MimeType string `json:"mime_type"`
+	Size     int64  `json:"size"`
+	Width    *int   `json:"width,omitempty"` // 画像のみ
+	Height   *int   `json:"height,omitempty"`
+	Filename string `json:"filename"` // アップロード時の元のファイル名（表示用）
+
+	Thumbnails []Thumbnail `json:"thumbnails"` // 画像の縮小版（小さい順。元画像より小さいサイズだけ）
+}
+
+// 保存名（中身のsha256＋拡張子）から公開URLを作る
+func attachmentURL(storageKey string) string {
+	return "/uploads/" + storageKey
+}
+
+// 表示用のファイル名を整える（パスや制御文字を落とし、長さを制限）
+func sanitizeFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == "/" {
+		return "file"
+	}
+	if utf8.RuneCountInString(name) > maxAttachmentFilename {
+		name = string([]rune(name)[:maxAttachmentFilename])
+	}
+	return name
+}
+
+// 中身から判定したMIME（パラメータは落とす）
+func sniffMimeType(f io.ReadSeeker) (string, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(head[:n]))
+	if err != nil {
+		return "", err
+	}
+	return mediaType, nil
+}
+
+// 保存名ごとのロック（トランザクションの終わりまで）
+// 同じ中身のファイルを「使われていないので削除」と「アップロードして参照を追加」が入れ違わないようにする
+func lockStorageKey(tx *sql.Tx, prefix, key string) error {
+	_, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1))`, prefix+"/"+key)
+	return err
+}
+
+// ファイルを中身のハッシュ名で保存先の prefix 以下に置く（同じ中身なら同じファイルを共有する）
+// ハッシュを先に計算するため、src は2回読む。tx があれば、参照を追加してコミットするまで削除されないようロックする
+func storeUpload(tx *sql.Tx, prefix string, src io.ReadSeeker, ext string) (key string, size int64, err error) {
+	h := sha256.New()
+	if size, err = io.Copy(h, src); err != nil {
+		return "", 0, err
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return "", 0, err
+	}
+
+	key = hex.EncodeToString(h.Sum(nil)) + ext
+	if tx != nil {
+		if err := lockStorageKey(tx, prefix, key); err != nil {
+			return "", 0, err
+		}
+	}
+	if err := files.Put(prefix+"/"+key, src, size, mime.TypeByExtension(ext)); err != nil {
+		return "", 0, err
+	}
+	return key, size, nil
+}
+
+// アップロード済みの添付をメッセージに紐づける（本人がアップロードした未使用のものだけ）
+func linkAttachments(tx *sql.Tx, messageID, uploaderID int, ids []int) error {
+	unique := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		unique[id] = true
+	}
+	if len(unique) > maxAttachmentsPerMsg {
+		return errInvalidAttachment
+	}
+	ids64 := make([]int64, 0, len(unique))
+	for id := range unique {
+		ids64 = append(ids64, int64(id))
+	}
+
+	res, err := tx.Exec(`
+		UPDATE attachments SET message_id = $1
+		WHERE id = ANY($2) AND uploader_id = $3 AND message_id IS NULL
+	`, messageID, pq.Array(ids64), uploaderID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n != int64(len(ids64)) {
+		return errInvalidAttachment
+	}
+	return nil
+}
+
+// 転送元メッセージの添付を転送先にも付ける（ファイルは共有）
+func copyAttachments(tx *sql.Tx, fromMessageID, toMessageID, uploaderID int) error {
+	// 参照を増やす保存名は、コミットまで削除されないようロックする（lockStorageKey と同じキー）
+	if _, err := tx.Exec(`
+		SELECT pg_advisory_xact_lock(hashtext($2 || '/' || storage_key))
+		FROM (SELECT DISTINCT storage_key FROM attachments WHERE message_id = $1 ORDER BY storage_key) k
+	`, fromMessageID, uploadPrefix); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`
+		INSERT INTO attachments (uploader_id, message_id, storage_key, filename, mime_type, size, width, height, thumbnails)
+		SELECT $3, $2, storage_key, filename, mime_type, size, width, height, thumbnails
+		FROM attachments WHERE message_id = $1
+		ORDER BY id
+	`, fromMessageID, toMessageID, uploaderID)
+	return err
+}
+
+// 各メッセージの添付を1回のクエリで埋める
+func fillAttachments(messages []MessageResponse) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(messages))
+	index := make(map[int]int, len(messages))
+	for i, msg := range messages {
+		ids[i] = int64(msg.ID)
+		index[msg.ID] = i
+	}
+
+	rows, err := db.Query(`
+		SELECT id, message_id, storage_key, filename, mime_type, size, width, height, thumbnails
+		FROM attachments
+		WHERE message_id = ANY($1)
+		ORDER BY id
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a Attachment
+		var messageID int
+		var key string
+		var thumbnails []byte
+		if err := rows.Scan(&a.ID, &messageID, &key, &a.Filename, &a.MimeType, &a.Size, &a.Width, &a.Height, &thumbnails); err != nil {
+			return err
+		}
+		a.URL = attachmentURL(key)
+		a.Thumbnails = decodeThumbnails(thumbnails, "/uploads/")
+		msg := &messages[index[messageID]]
+		msg.Attachments = append(msg.Attachments, a)
+	}
+	return rows.Err()
+}
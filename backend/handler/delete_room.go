@@ -3,7 +3,8 @@ package handler
 import (
 	"encoding/json"
 	"net/http"
-	"strconv"
+
+	"backend/auth"
 )
 
 // リクエスト用構造体
@@ -13,17 +14,8 @@ type DeleteRoomRequest struct {
 
 // チャットルーム削除ハンドラー
 func DeleteRoomHandler(w http.ResponseWriter, r *http.Request) {
-	// JWTからユーザーID取得
-	userIDStr, err := GetUserIDFromToken(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-	userID, err := strconv.Atoi(userIDStr)
-	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
-		return
-	}
+	// ログイン中のユーザーID
+	userID := auth.UserID(r)
 
 	// リクエストボディをパース
 	var req DeleteRoomRequest
@@ -32,24 +24,41 @@ func DeleteRoomHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// ルームの作成者を確認
-	var creatorID int
-	err = db.QueryRow("SELECT created_by FROM chat_rooms WHERE id = $1", req.RoomID).Scan(&creatorID)
-	if err != nil {
-		http.Error(w, "Room not found or DB error", http.StatusNotFound)
+	// ルームを削除できるのはオーナーだけ
+	if !requirePermission(w, userID, actionDeleteRoom, req.RoomID) {
 		return
 	}
-	if creatorID != userID {
-		http.Error(w, "You are not the creator of this room", http.StatusForbidden)
+
+	// 削除前にメンバーを控えておく（WebSocketの配信先から外すため）
+	memberIDs, err := roomMemberIDs(req.RoomID)
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
 		return
 	}
 
 	// ルーム削除（関連テーブルにON DELETE CASCADE前提）
-	_, err = db.Exec("DELETE FROM chat_rooms WHERE id = $1", req.RoomID)
+	tx, err := db.Begin()
 	if err != nil {
 		http.Error(w, "Failed to delete room", http.StatusInternalServerError)
 		return
 	}
+	defer tx.Rollback()
+	storageKeys, err := purgeRoom(tx, req.RoomID)
+	if err != nil {
+		http.Error(w, "Failed to delete room", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to delete room", http.StatusInternalServerError)
+		return
+	}
+	for _, key := range storageKeys {
+		removeUnusedUpload(key)
+	}
+
+	for _, memberID := range memberIDs {
+		chatHub.RemoveMember(req.RoomID, memberID)
+	}
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Room deleted successfully"))
@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/storage"
+)
+
+// 期限付きURLの有効期間（ダウンロード時にリダイレクトする先）
+const presignTTL = 15 * time.Minute
+
+// 中身のハッシュ名（sha256 の16進64文字。サムネイルは "_サイズ" 付き）で保存したファイルか
+// 同じ名前の中身は変わらないので、ブラウザにずっとキャッシュさせてよい
+func contentAddressed(name string) bool {
+	base := strings.TrimSuffix(name, path.Ext(name))
+	if i := strings.IndexByte(base, '_'); i >= 0 {
+		base = base[:i]
+	}
+	if len(base) != 64 {
+		return false
+	}
+	for _, c := range base {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// アップロードファイルの保存先（STORAGE_BACKEND で切り替え）
+var files storage.Storage
+
+// 保存先を初期化する関数（アプリ起動時に一度だけ呼ばれる）
+func InitStorage() {
+	var err error
+	// S3互換ストレージは同時に起動したコンテナの準備ができるまで少し待つ
+	for attempt := 1; attempt <= 5; attempt++ {
+		if files, err = storage.FromEnv(); err == nil {
+			return
+		}
+		log.Println("⚠️ storage not ready, retrying:", err)
+		time.Sleep(2 * time.Second)
+	}
+	log.Fatal("Failed to initialize storage:", err)
+}
+
+// GET /{prefix}/{name}：保存先のファイルを返す
+// 期限付きURLを出せる保存先ならそこへリダイレクトし、出せなければサーバーが中継する
+func FileHandler(prefix string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		name := strings.TrimPrefix(r.URL.Path, "/"+prefix+"/")
+		if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+			http.NotFound(w, r)
+			return
+		}
+		key := prefix + "/" + name
+
+		url, err := files.URL(key, presignTTL)
+		if err != nil {
+			http.Error(w, "Failed to sign URL", http.StatusInternalServerError)
+			return
+		}
+		if url != "" {
+			w.Header().Set("Cache-Control", "private, max-age=600") // 署名の期限より短く
+			http.Redirect(w, r, url, http.StatusFound)
+			return
+		}
+
+		rc, obj, err := files.Open(key)
+		if err == storage.ErrNotFound {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			http.Error(w, "Failed to read file", http.StatusInternalServerError)
+			return
+		}
+		defer rc.Close()
+
+		if obj.ContentType == "" {
+			obj.ContentType = mime.TypeByExtension(path.Ext(name))
+		}
+		if obj.ContentType != "" {
+			w.Header().Set("Content-Type", obj.ContentType)
+		}
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if contentAddressed(name) {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			// 以前の形式（元のファイル名のまま）は上書きされうるので毎回確認させる
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+
+		// ローカルのファイルなら Range や If-Modified-Since にも対応できる
+		if rs, ok := rc.(io.ReadSeeker); ok {
+			http.ServeContent(w, r, name, obj.ModTime, rs)
+			return
+		}
+		if obj.Size >= 0 {
+			w.Header().Set("Content-Length", strconv.FormatInt(obj.Size, 10))
+		}
+		if r.Method == http.MethodHead {
+			return
+		}
+		io.Copy(w, rc)
+	}
+}
@@ -0,0 +1,516 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"backend/auth"
+
+	"github.com/lib/pq"
+)
+
+const maxRoomNameLength = 100
+
+var errNotGroup = errors.New("only group rooms can be managed")
+
+// システムメッセージの内容（ルームに書き込まれる操作の記録）
+type SystemEvent struct {
+	Action  string `json:"action"`             // renamed / members_invited / member_joined / member_removed / member_left / owner_transferred / role_changed
+	ActorID int    `json:"actor_id"`           // 操作したユーザー
+	UserIDs []int  `json:"user_ids,omitempty"` // 対象のユーザー
+	Name    string `json:"name,omitempty"`     // renamed の新しい名前
+	Role    string `json:"role,omitempty"`     // role_changed の新しい役割
+}
+
+type RenameRoomRequest struct {
+	Name string `json:"name"`
+}
+
+type AddMembersRequest struct {
+	UserIDs []int `json:"user_ids"`
+}
+
+type TransferOwnerRequest struct {
+	UserID int `json:"user_id"`
+}
+
+type SetRoleRequest struct {
+	Role string `json:"role"` // admin / member / read_only
+}
+
+// /rooms/{id}/... をサブパスで振り分ける
+func RoomsByIDRouter(w http.ResponseWriter, r *http.Request) {
+	idStr, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/rooms/"), "/")
+	roomID, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case sub == "name":
+		RenameRoomHandler(w, r, roomID)
+	case sub == "members", sub == "invitations": // members は旧クライアント用（招待になる）
+		RoomInvitationsHandler(w, r, roomID)
+	case strings.HasPrefix(sub, "invitations/"):
+		CancelInvitationHandler(w, r, roomID, strings.TrimPrefix(sub, "invitations/"))
+	case sub == "invite_links":
+		RoomInviteLinksHandler(w, r, roomID)
+	case strings.HasPrefix(sub, "invite_links/"):
+		RevokeInviteLinkHandler(w, r, roomID, strings.TrimPrefix(sub, "invite_links/"))
+	case strings.HasPrefix(sub, "members/") && strings.HasSuffix(sub, "/role"):
+		SetMemberRoleHandler(w, r, roomID, strings.TrimSuffix(strings.TrimPrefix(sub, "members/"), "/role"))
+	case strings.HasPrefix(sub, "members/"):
+		RemoveRoomMemberHandler(w, r, roomID, strings.TrimPrefix(sub, "members/"))
+	case sub == "leave":
+		LeaveRoomHandler(w, r, roomID)
+	case sub == "owner":
+		TransferRoomOwnerHandler(w, r, roomID)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// グループをロックする（同じルームへの操作を順番に処理するため）
+// 役割は room_members.role が正で、created_by はオーナーと同じ値に保つ
+func lockGroup(tx *sql.Tx, roomID int) error {
+	var isGroup bool
+	err := tx.QueryRow(`SELECT is_group FROM chat_rooms WHERE id = $1 FOR UPDATE`, roomID).Scan(&isGroup)
+	if err != nil {
+		return err
+	}
+	if !isGroup {
+		return errNotGroup
+	}
+	return nil
+}
+
+// lockGroup のエラーをレスポンスにする
+func writeGroupError(w http.ResponseWriter, err error) {
+	switch err {
+	case sql.ErrNoRows:
+		http.Error(w, "Room not found", http.StatusNotFound)
+	case errNotGroup:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "DB error", http.StatusInternalServerError)
+	}
+}
+
+// グループをロックし、操作が許される役割か確認する（エラー時はレスポンスを書いて ok=false）
+func beginRoomOp(w http.ResponseWriter, roomID, userID int, action roomAction) (tx *sql.Tx, role string, ok bool) {
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return nil, "", false
+	}
+	if err := lockGroup(tx, roomID); err != nil {
+		tx.Rollback()
+		writeGroupError(w, err)
+		return nil, "", false
+	}
+	role, err = roomRole(tx, roomID, userID)
+	if err != nil && err != errNotRoomMember {
+		tx.Rollback()
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return nil, "", false
+	}
+	if !roleAllows(role, action) {
+		tx.Rollback()
+		http.Error(w, "Forbidden: you are not allowed to "+string(action)+" in this room", http.StatusForbidden)
+		return nil, "", false
+	}
+	return tx, role, true
+}
+
+// ユーザーID → ユーザー名
+func usernamesByID(ids []int) map[int]string {
+	names := make(map[int]string, len(ids))
+	ids64 := make([]int64, len(ids))
+	for i, id := range ids {
+		ids64[i] = int64(id)
+	}
+	rows, err := db.Query(`SELECT id, username FROM users WHERE id = ANY($1)`, pq.Array(ids64))
+	if err != nil {
+		log.Println("❌ username lookup error:", err)
+		return names
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var name string
+		if rows.Scan(&id, &name) == nil {
+			names[id] = name
+		}
+	}
+	return names
+}
+
+// 操作の記録をシステムメッセージとしてルームに書き込み、配信する
+func postSystemMessage(roomID int, ev SystemEvent) {
+	names := usernamesByID(append([]int{ev.ActorID}, ev.UserIDs...))
+	targets := make([]string, len(ev.UserIDs))
+	for i, id := range ev.UserIDs {
+		targets[i] = names[id]
+	}
+	actor, target := names[ev.ActorID], strings.Join(targets, "、")
+
+	var content string
+	switch ev.Action {
+	case "renamed":
+		content = fmt.Sprintf("%s がグループ名を「%s」に変更しました", actor, ev.Name)
+	case "members_invited":
+		content = fmt.Sprintf("%s が %s を招待しました", actor, target)
+	case "member_joined":
+		content = fmt.Sprintf("%s が参加しました", actor)
+	case "member_removed":
+		content = fmt.Sprintf("%s が %s を削除しました", actor, target)
+	case "member_left":
+		content = fmt.Sprintf("%s が退出しました", actor)
+	case "owner_transferred":
+		content = fmt.Sprintf("%s がオーナーになりました", target)
+	case "role_changed":
+		content = fmt.Sprintf("%s が %s の役割を %s に変更しました", actor, target, ev.Role)
+	}
+
+	msg, err := createMessage(Message{RoomID: roomID, SenderID: ev.ActorID, Content: content, System: &ev})
+	if err != nil {
+		log.Println("❌ system message error:", err)
+		return
+	}
+	broadcastNewMessage(msg, nil)
+}
+
+// ルームを削除し、使われなくなった添付ファイルも消す（関連テーブルは ON DELETE CASCADE 前提）
+func purgeRoom(tx *sql.Tx, roomID int) (storageKeys []string, err error) {
+	rows, err := tx.Query(`
+		SELECT DISTINCT a.storage_key
+		FROM attachments a JOIN messages m ON m.id = a.message_id
+		WHERE m.room_id = $1
+	`, roomID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		storageKeys = append(storageKeys, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`DELETE FROM chat_rooms WHERE id = $1`, roomID)
+	return storageKeys, err
+}
+
+// オーナーを変更する（created_by も合わせる）
+func setOwner(tx *sql.Tx, roomID, userID int) error {
+	if _, err := tx.Exec(`UPDATE room_members SET role = $1 WHERE room_id = $2 AND user_id = $3`, roleOwner, roomID, userID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`UPDATE chat_rooms SET created_by = $1 WHERE id = $2`, userID, roomID)
+	return err
+}
+
+// PUT /rooms/{id}/name：グループ名を変更
+func RenameRoomHandler(w http.ResponseWriter, r *http.Request, roomID int) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := auth.UserID(r)
+
+	var req RenameRoomRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || utf8.RuneCountInString(name) > maxRoomNameLength {
+		http.Error(w, "Invalid name", http.StatusBadRequest)
+		return
+	}
+
+	tx, _, ok := beginRoomOp(w, roomID, userID, actionManageRoom)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE chat_rooms SET room_name = $1 WHERE id = $2`, name, roomID); err != nil {
+		http.Error(w, "Failed to rename room", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to rename room", http.StatusInternalServerError)
+		return
+	}
+
+	postSystemMessage(roomID, SystemEvent{Action: "renamed", ActorID: userID, Name: name})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DELETE /rooms/{id}/members/{user_id}：メンバーを削除（自分より下の役割の人だけ。自分は退出を使う）
+func RemoveRoomMemberHandler(w http.ResponseWriter, r *http.Request, roomID int, targetIDStr string) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := auth.UserID(r)
+
+	targetID, err := strconv.Atoi(targetIDStr)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	if targetID == userID {
+		http.Error(w, "Use /rooms/{id}/leave to leave the group", http.StatusBadRequest)
+		return
+	}
+
+	tx, role, ok := beginRoomOp(w, roomID, userID, actionManageMembers)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	targetRole, err := roomRole(tx, roomID, targetID)
+	if err == errNotRoomMember {
+		http.Error(w, "User is not a member of this room", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	if roleRank[targetRole] >= roleRank[role] {
+		http.Error(w, "Forbidden: cannot remove a member with the same or higher role", http.StatusForbidden)
+		return
+	}
+
+	if _, err := tx.Exec(`DELETE FROM room_members WHERE room_id = $1 AND user_id = $2`, roomID, targetID); err != nil {
+		http.Error(w, "Failed to remove member", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to remove member", http.StatusInternalServerError)
+		return
+	}
+
+	// 削除された本人にも記録が届くよう、配信先から外す前に書き込む
+	postSystemMessage(roomID, SystemEvent{Action: "member_removed", ActorID: userID, UserIDs: []int{targetID}})
+	chatHub.RemoveMember(roomID, targetID)
+	chatHub.SendToUser(targetID, map[string]interface{}{"type": "room_left", "room_id": roomID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// POST /rooms/{id}/leave：グループから退出
+// オーナーが抜けると管理者（いなければメンバー）のうち一番古くからいる人がオーナーになり、
+// 読み取り専用のメンバーしか残らなければオーナー不在になる。最後の1人が抜けるとルームを削除する
+func LeaveRoomHandler(w http.ResponseWriter, r *http.Request, roomID int) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := auth.UserID(r)
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := lockGroup(tx, roomID); err != nil {
+		writeGroupError(w, err)
+		return
+	}
+
+	var role string
+	err = tx.QueryRow(`DELETE FROM room_members WHERE room_id = $1 AND user_id = $2 RETURNING role`, roomID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		http.Error(w, errNotRoomMember.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to leave room", http.StatusInternalServerError)
+		return
+	}
+
+	var remaining int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM room_members WHERE room_id = $1`, roomID).Scan(&remaining); err != nil {
+		http.Error(w, "Failed to leave room", http.StatusInternalServerError)
+		return
+	}
+
+	if remaining == 0 {
+		// 最後の1人だったのでルームごと片付ける
+		storageKeys, err := purgeRoom(tx, roomID)
+		if err != nil {
+			http.Error(w, "Failed to delete room", http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Failed to delete room", http.StatusInternalServerError)
+			return
+		}
+		for _, key := range storageKeys {
+			removeUnusedUpload(key)
+		}
+	} else {
+		// オーナーが抜けるときは管理者→メンバーの順で古参に譲る
+		// 読み取り専用のメンバーしか残っていなければ昇格はさせず、オーナー不在のまま残す
+		// （読み取り専用にした意図を勝手に覆さない。メッセージと招待リンクはそのまま使える）
+		nextOwnerID := 0
+		if role == roleOwner {
+			err := tx.QueryRow(`
+				SELECT user_id FROM room_members WHERE room_id = $1 AND role <> $2
+				ORDER BY CASE role WHEN 'admin' THEN 0 ELSE 1 END, joined_at, user_id
+				LIMIT 1
+			`, roomID, roleReadOnly).Scan(&nextOwnerID)
+			switch {
+			case err == sql.ErrNoRows:
+				_, err = tx.Exec(`UPDATE chat_rooms SET created_by = NULL WHERE id = $1`, roomID)
+			case err == nil:
+				err = setOwner(tx, roomID, nextOwnerID)
+			}
+			if err != nil {
+				http.Error(w, "Failed to transfer ownership", http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Failed to leave room", http.StatusInternalServerError)
+			return
+		}
+		postSystemMessage(roomID, SystemEvent{Action: "member_left", ActorID: userID})
+		if nextOwnerID != 0 {
+			postSystemMessage(roomID, SystemEvent{Action: "owner_transferred", ActorID: userID, UserIDs: []int{nextOwnerID}})
+		}
+	}
+
+	chatHub.RemoveMember(roomID, userID)
+	// 同じユーザーの他のタブ・端末にも知らせる
+	chatHub.SendToUser(userID, map[string]interface{}{"type": "room_left", "room_id": roomID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PUT /rooms/{id}/owner：オーナーを他のメンバーに譲る
+func TransferRoomOwnerHandler(w http.ResponseWriter, r *http.Request, roomID int) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := auth.UserID(r)
+
+	var req TransferOwnerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.UserID == userID {
+		http.Error(w, "You are already the owner", http.StatusBadRequest)
+		return
+	}
+
+	tx, _, ok := beginRoomOp(w, roomID, userID, actionTransferOwner)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := roomRole(tx, roomID, req.UserID); err == errNotRoomMember {
+		http.Error(w, "User is not a member of this room", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+
+	// 元のオーナーは管理者として残る
+	if _, err := tx.Exec(`UPDATE room_members SET role = $1 WHERE room_id = $2 AND user_id = $3`, roleAdmin, roomID, userID); err != nil {
+		http.Error(w, "Failed to transfer ownership", http.StatusInternalServerError)
+		return
+	}
+	if err := setOwner(tx, roomID, req.UserID); err != nil {
+		http.Error(w, "Failed to transfer ownership", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to transfer ownership", http.StatusInternalServerError)
+		return
+	}
+
+	postSystemMessage(roomID, SystemEvent{Action: "owner_transferred", ActorID: userID, UserIDs: []int{req.UserID}})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PUT /rooms/{id}/members/{user_id}/role：役割を変更（admin / member / read_only。オーナーのみ）
+func SetMemberRoleHandler(w http.ResponseWriter, r *http.Request, roomID int, targetIDStr string) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := auth.UserID(r)
+
+	targetID, err := strconv.Atoi(targetIDStr)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	var req SetRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	// オーナーは譲渡でだけ変わる
+	if !validRole(req.Role) || req.Role == roleOwner {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+	if targetID == userID {
+		http.Error(w, "Use /rooms/{id}/owner to hand over ownership", http.StatusBadRequest)
+		return
+	}
+
+	tx, _, ok := beginRoomOp(w, roomID, userID, actionManageRoles)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE room_members SET role = $1 WHERE room_id = $2 AND user_id = $3 AND role <> $1`, req.Role, roomID, targetID)
+	if err != nil {
+		http.Error(w, "Failed to update role", http.StatusInternalServerError)
+		return
+	}
+	changed, _ := res.RowsAffected()
+	if changed == 0 {
+		// 役割が同じか、メンバーでない
+		if _, err := roomRole(tx, roomID, targetID); err == errNotRoomMember {
+			http.Error(w, "User is not a member of this room", http.StatusNotFound)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to update role", http.StatusInternalServerError)
+		return
+	}
+
+	if changed > 0 {
+		postSystemMessage(roomID, SystemEvent{Action: "role_changed", ActorID: userID, UserIDs: []int{targetID}, Role: req.Role})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"backend/imageproc"
+)
+
+// サムネイルの長辺のサイズ
+var (
+	attachmentThumbnailSizes = []int{160, 480, 1024}
+	avatarThumbnailSizes     = []int{64, 256}
+)
+
+// メタデータ除去などの処理をする画像の種類
+var processedImageTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// 画像処理のエラーに対応するステータス（クライアントの画像が原因でなければ ok=false）
+func imageErrorStatus(err error) (status int, ok bool) {
+	switch err {
+	case imageproc.ErrTooLarge:
+		return http.StatusRequestEntityTooLarge, true
+	case imageproc.ErrUnsupported:
+		return http.StatusBadRequest, true
+	}
+	return 0, false
+}
+
+// クライアントに返すサムネイル
+type Thumbnail struct {
+	Size   int    `json:"size"` // 長辺のピクセル数
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// DBに保存するサムネイル情報（URLは保存先から組み立てる）
+type storedThumbnail struct {
+	Size   int    `json:"size"`
+	Key    string `json:"key"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// 画像を処理して、元画像とサムネイルを保存先の prefix 以下に置く
+// 元画像は処理後の中身のハッシュ名、サムネイルは「ハッシュ名_サイズ.拡張子」（tx は storeUpload と同じ）
+func storeProcessedImage(tx *sql.Tx, prefix string, data []byte, sizes []int) (key string, orig imageproc.Image, thumbs []storedThumbnail, err error) {
+	res, err := imageproc.Process(data, sizes)
+	if err != nil {
+		return "", orig, nil, err
+	}
+	orig = res.Original
+
+	key, _, err = storeUpload(tx, prefix, bytes.NewReader(orig.Data), orig.Ext)
+	if err != nil {
+		return "", orig, nil, err
+	}
+
+	base := strings.TrimSuffix(key, orig.Ext)
+	for _, size := range sizes {
+		t, ok := res.Thumbnails[size]
+		if !ok {
+			continue
+		}
+		thumbKey := base + "_" + strconv.Itoa(size) + t.Ext
+		// 中身は元画像から決まるので、既にあれば上書きしても同じ
+		if err := files.Put(prefix+"/"+thumbKey, bytes.NewReader(t.Data), int64(len(t.Data)), t.MimeType); err != nil {
+			return "", orig, nil, err
+		}
+		thumbs = append(thumbs, storedThumbnail{Size: size, Key: thumbKey, Width: t.Width, Height: t.Height})
+	}
+	return key, orig, thumbs, nil
+}
+
+// サムネイル情報をDBに保存する形（JSONB）にする
+func encodeThumbnails(stored []storedThumbnail) []byte {
+	if stored == nil {
+		stored = []storedThumbnail{}
+	}
+	raw, _ := json.Marshal(stored)
+	return raw
+}
+
+// 保存したサムネイル情報（JSONB）をURL付きに変換
+func decodeThumbnails(raw []byte, urlPrefix string) []Thumbnail {
+	var stored []storedThumbnail
+	if err := json.Unmarshal(raw, &stored); err != nil {
+		return []Thumbnail{}
+	}
+	return thumbnailURLs(stored, urlPrefix)
+}
+
+// 保存名にURLを付ける
+func thumbnailURLs(stored []storedThumbnail, urlPrefix string) []Thumbnail {
+	thumbs := make([]Thumbnail, len(stored))
+	for i, t := range stored {
+		thumbs[i] = Thumbnail{Size: t.Size, URL: urlPrefix + t.Key, Width: t.Width, Height: t.Height}
+	}
+	return thumbs
+}
+
+// 元画像のサムネイルをすべて消す（サイズと拡張子の組み合わせから名前が決まる）
+func removeThumbnails(prefix, key string, sizes []int) {
+	base := strings.TrimSuffix(key, path.Ext(key))
+	for _, size := range sizes {
+		for _, ext := range []string{".jpg", ".png"} {
+			if err := files.Delete(prefix + "/" + base + "_" + strconv.Itoa(size) + ext); err != nil {
+				log.Println("❌ failed to remove thumbnail:", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,718 @@
+package handler
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/auth"
+
+	"github.com/lib/pq"
+)
+
+const (
+	defaultInviteLinkTTL = 7 * 24 * time.Hour  // expires_in を省略したときの有効期間
+	maxInviteLinkTTL     = 30 * 24 * time.Hour // 招待リンクの最長の有効期間
+	maxInviteLinkUses    = 1000                // max_uses の上限
+)
+
+// 招待リンクの URL の先頭（フロントエンドの /invite/{token} ページ）
+var inviteBaseURL = func() string {
+	if s := os.Getenv("INVITE_BASE_URL"); s != "" {
+		return strings.TrimSuffix(s, "/")
+	}
+	return "http://localhost:3001/invite"
+}()
+
+// グループへの招待（招待された人が承諾するとメンバーになる）
+type Invitation struct {
+	ID          int     `json:"id"`
+	RoomID      int     `json:"room_id"`
+	RoomName    string  `json:"room_name"`
+	InviterID   *int    `json:"inviter_id"`   // 招待した人（退会していれば null）
+	InviterName *string `json:"inviter_name"` // 招待した人の名前
+	InviteeID   int     `json:"invitee_id"`
+	Status      string  `json:"status"` // pending / accepted / declined / cancelled
+	CreatedAt   string  `json:"created_at"`
+	RespondedAt *string `json:"responded_at"`
+}
+
+// 招待リンク（URL を知っている人は承諾なしで参加できる）
+type InviteLink struct {
+	ID        int     `json:"id"`
+	RoomID    int     `json:"room_id"`
+	Token     string  `json:"token"`
+	URL       string  `json:"url"`
+	CreatedBy *int    `json:"created_by"`
+	ExpiresAt string  `json:"expires_at"`
+	MaxUses   *int    `json:"max_uses"` // null なら回数無制限
+	UseCount  int     `json:"use_count"`
+	CreatedAt string  `json:"created_at"`
+	RevokedAt *string `json:"revoked_at"`
+
+	expires time.Time
+}
+
+type CreateInviteLinkRequest struct {
+	ExpiresIn int `json:"expires_in"` // 有効期間（秒）。0 なら7日
+	MaxUses   int `json:"max_uses"`   // 使える回数。0 なら無制限
+}
+
+// 招待リンクを開いたときのプレビュー
+type InviteLinkPreview struct {
+	RoomID        int    `json:"room_id"`
+	RoomName      string `json:"room_name"`
+	MemberCount   int    `json:"member_count"`
+	ExpiresAt     string `json:"expires_at"`
+	AlreadyMember bool   `json:"already_member"`
+}
+
+const invitationColumns = `
+	i.id, i.room_id, cr.room_name, i.inviter_id, u.username, i.invitee_id, i.status, i.created_at, i.responded_at
+`
+
+const invitationFrom = `
+	FROM room_invitations i
+	JOIN chat_rooms cr ON cr.id = i.room_id
+	LEFT JOIN users u ON u.id = i.inviter_id
+`
+
+func scanInvitation(row interface{ Scan(...interface{}) error }) (Invitation, error) {
+	var inv Invitation
+	var createdAt time.Time
+	var respondedAt *time.Time
+	err := row.Scan(&inv.ID, &inv.RoomID, &inv.RoomName, &inv.InviterID, &inv.InviterName,
+		&inv.InviteeID, &inv.Status, &createdAt, &respondedAt)
+	inv.CreatedAt = createdAt.Format(time.RFC3339)
+	inv.RespondedAt = formatTime(respondedAt)
+	return inv, err
+}
+
+// 招待の状態を招待された人と招待した人に知らせる（status で新規・承諾・辞退・取り消しを区別する）
+func notifyInvitation(inv Invitation) {
+	event := map[string]interface{}{"type": "invitation", "invitation": inv}
+	chatHub.SendToUser(inv.InviteeID, event)
+	if inv.InviterID != nil && *inv.InviterID != inv.InviteeID {
+		chatHub.SendToUser(*inv.InviterID, event)
+	}
+}
+
+// ユーザーを招待する（存在しないユーザー・メンバー・招待中の人は飛ばす）
+func inviteUsers(tx *sql.Tx, roomID, inviterID int, userIDs []int) ([]Invitation, error) {
+	ids := make([]int64, 0, len(userIDs))
+	for _, id := range userIDs {
+		if id != inviterID {
+			ids = append(ids, int64(id))
+		}
+	}
+
+	rows, err := tx.Query(`
+		WITH inserted AS (
+			INSERT INTO room_invitations (room_id, inviter_id, invitee_id)
+			SELECT $1, $2, u.id FROM users u
+			WHERE u.id = ANY($3)
+			  AND NOT EXISTS (SELECT 1 FROM room_members rm WHERE rm.room_id = $1 AND rm.user_id = u.id)
+			ON CONFLICT DO NOTHING
+			RETURNING *
+		)
+		SELECT `+invitationColumns+`
+		FROM inserted i
+		JOIN chat_rooms cr ON cr.id = i.room_id
+		LEFT JOIN users u ON u.id = i.inviter_id
+		ORDER BY i.id
+	`, roomID, inviterID, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []Invitation{}
+	for rows.Next() {
+		inv, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, inv)
+	}
+	return invitations, rows.Err()
+}
+
+// メンバーにする（既にメンバーなら false）。招待中のものは承諾済みにする
+// 参加前の履歴がすべて未読にならないよう、既読位置は今ある最新のメッセージにする
+func joinRoom(tx *sql.Tx, roomID, userID int) (bool, error) {
+	res, err := tx.Exec(`
+		INSERT INTO room_members (room_id, user_id, last_read_message_id)
+		SELECT $1, $2, (SELECT COALESCE(MAX(id), 0) FROM messages WHERE room_id = $1)
+		WHERE NOT EXISTS (SELECT 1 FROM room_members WHERE room_id = $1 AND user_id = $2)
+	`, roomID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	if n > 0 {
+		// 既存のスレッドも最新の返信まで既読にする（参加前の返信が未読に並ばないように）
+		if _, err := tx.Exec(`
+			INSERT INTO thread_reads (parent_id, user_id, last_read_reply_id)
+			SELECT parent_id, $2, MAX(id) FROM messages
+			WHERE room_id = $1 AND parent_id IS NOT NULL
+			GROUP BY parent_id
+			ON CONFLICT (parent_id, user_id) DO UPDATE
+			SET last_read_reply_id = GREATEST(thread_reads.last_read_reply_id, EXCLUDED.last_read_reply_id)
+		`, roomID, userID); err != nil {
+			return false, err
+		}
+	}
+	if _, err := tx.Exec(`
+		UPDATE room_invitations SET status = 'accepted', responded_at = NOW()
+		WHERE room_id = $1 AND invitee_id = $2 AND status = 'pending'
+	`, roomID, userID); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// 参加したことを本人の接続とルームに知らせる（コミット後に呼ぶ）
+func announceJoin(roomID, userID int) {
+	chatHub.AddMember(roomID, userID)
+	chatHub.SendToUser(userID, map[string]interface{}{"type": "room_joined", "room_id": roomID})
+	postSystemMessage(roomID, SystemEvent{Action: "member_joined", ActorID: userID})
+}
+
+// POST /rooms/{id}/invitations（旧: /rooms/{id}/members）：ユーザーを招待
+// GET  /rooms/{id}/invitations：承諾待ちの招待一覧
+func RoomInvitationsHandler(w http.ResponseWriter, r *http.Request, roomID int) {
+	userID := auth.UserID(r)
+
+	switch r.Method {
+	case http.MethodPost:
+		var req AddMembersRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.UserIDs) == 0 {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		tx, _, ok := beginRoomOp(w, roomID, userID, actionManageMembers)
+		if !ok {
+			return
+		}
+		defer tx.Rollback()
+
+		invitations, err := inviteUsers(tx, roomID, userID, req.UserIDs)
+		if err != nil {
+			http.Error(w, "Failed to invite members", http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Failed to invite members", http.StatusInternalServerError)
+			return
+		}
+
+		if len(invitations) > 0 {
+			invited := make([]int, len(invitations))
+			for i, inv := range invitations {
+				invited[i] = inv.InviteeID
+				notifyInvitation(inv)
+			}
+			postSystemMessage(roomID, SystemEvent{Action: "members_invited", ActorID: userID, UserIDs: invited})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(invitations)
+
+	case http.MethodGet:
+		if !requirePermission(w, userID, actionManageMembers, roomID) {
+			return
+		}
+		rows, err := db.Query(`SELECT `+invitationColumns+invitationFrom+`
+			WHERE i.room_id = $1 AND i.status = 'pending'
+			ORDER BY i.created_at DESC
+		`, roomID)
+		if err != nil {
+			http.Error(w, "Failed to fetch invitations", http.StatusInternalServerError)
+			return
+		}
+		writeInvitations(w, rows)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeInvitations(w http.ResponseWriter, rows *sql.Rows) {
+	defer rows.Close()
+	invitations := []Invitation{}
+	for rows.Next() {
+		inv, err := scanInvitation(rows)
+		if err != nil {
+			http.Error(w, "Failed to parse invitations", http.StatusInternalServerError)
+			return
+		}
+		invitations = append(invitations, inv)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "Failed to parse invitations", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invitations)
+}
+
+// DELETE /rooms/{id}/invitations/{invitation_id}：承諾待ちの招待を取り消す
+func CancelInvitationHandler(w http.ResponseWriter, r *http.Request, roomID int, invitationIDStr string) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := auth.UserID(r)
+
+	invitationID, err := strconv.Atoi(invitationIDStr)
+	if err != nil {
+		http.Error(w, "Invalid invitation ID", http.StatusBadRequest)
+		return
+	}
+
+	tx, _, ok := beginRoomOp(w, roomID, userID, actionManageMembers)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	inv, err := scanInvitation(tx.QueryRow(`
+		WITH updated AS (
+			UPDATE room_invitations SET status = 'cancelled', responded_at = NOW()
+			WHERE id = $1 AND room_id = $2 AND status = 'pending'
+			RETURNING *
+		)
+		SELECT `+invitationColumns+`
+		FROM updated i
+		JOIN chat_rooms cr ON cr.id = i.room_id
+		LEFT JOIN users u ON u.id = i.inviter_id
+	`, invitationID, roomID))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Pending invitation not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to cancel invitation", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to cancel invitation", http.StatusInternalServerError)
+		return
+	}
+
+	notifyInvitation(inv)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET /invitations：自分宛ての承諾待ちの招待
+func MyInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := auth.UserID(r)
+
+	rows, err := db.Query(`SELECT `+invitationColumns+invitationFrom+`
+		WHERE i.invitee_id = $1 AND i.status = 'pending'
+		ORDER BY i.created_at DESC
+	`, userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch invitations", http.StatusInternalServerError)
+		return
+	}
+	writeInvitations(w, rows)
+}
+
+// /invitations/{id}/accept・/invitations/{id}/decline を振り分ける
+func InvitationsByIDRouter(w http.ResponseWriter, r *http.Request) {
+	idStr, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/invitations/"), "/")
+	invitationID, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, "Invalid invitation ID", http.StatusBadRequest)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch sub {
+	case "accept":
+		respondInvitation(w, r, invitationID, true)
+	case "decline":
+		respondInvitation(w, r, invitationID, false)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// 招待に返事をする（承諾ならメンバーにしてルームIDを返す）
+func respondInvitation(w http.ResponseWriter, r *http.Request, invitationID int, accept bool) {
+	userID := auth.UserID(r)
+
+	// ルーム → 招待の順にロックする（取り消しと同じ順番）
+	var roomID int
+	err := db.QueryRow(`SELECT room_id FROM room_invitations WHERE id = $1 AND invitee_id = $2`, invitationID, userID).Scan(&roomID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	if err := lockGroup(tx, roomID); err != nil {
+		writeGroupError(w, err)
+		return
+	}
+
+	var status string
+	err = tx.QueryRow(`SELECT status FROM room_invitations WHERE id = $1 FOR UPDATE`, invitationID).Scan(&status)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	if status != "pending" {
+		http.Error(w, "Invitation is already "+status, http.StatusConflict)
+		return
+	}
+
+	joined := false
+	if accept {
+		// joinRoom が招待を承諾済みにする
+		if joined, err = joinRoom(tx, roomID, userID); err != nil {
+			http.Error(w, "Failed to join room", http.StatusInternalServerError)
+			return
+		}
+	} else if _, err := tx.Exec(`
+		UPDATE room_invitations SET status = 'declined', responded_at = NOW() WHERE id = $1
+	`, invitationID); err != nil {
+		http.Error(w, "Failed to decline invitation", http.StatusInternalServerError)
+		return
+	}
+
+	inv, err := scanInvitation(tx.QueryRow(`SELECT `+invitationColumns+invitationFrom+` WHERE i.id = $1`, invitationID))
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to respond to invitation", http.StatusInternalServerError)
+		return
+	}
+
+	notifyInvitation(inv)
+	if joined {
+		announceJoin(roomID, userID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(inv)
+}
+
+// 推測されないリンク用トークン
+func newInviteToken() (string, error) {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+const inviteLinkColumns = `id, room_id, token, created_by, expires_at, max_uses, use_count, created_at, revoked_at`
+
+func scanInviteLink(row interface{ Scan(...interface{}) error }) (InviteLink, error) {
+	var link InviteLink
+	var expiresAt, createdAt time.Time
+	var revokedAt *time.Time
+	err := row.Scan(&link.ID, &link.RoomID, &link.Token, &link.CreatedBy, &expiresAt,
+		&link.MaxUses, &link.UseCount, &createdAt, &revokedAt)
+	link.URL = inviteBaseURL + "/" + link.Token
+	link.expires = expiresAt
+	link.ExpiresAt = expiresAt.Format(time.RFC3339)
+	link.CreatedAt = createdAt.Format(time.RFC3339)
+	link.RevokedAt = formatTime(revokedAt)
+	return link, err
+}
+
+// POST /rooms/{id}/invite_links：招待リンクを発行
+// GET  /rooms/{id}/invite_links：使える招待リンクの一覧
+func RoomInviteLinksHandler(w http.ResponseWriter, r *http.Request, roomID int) {
+	userID := auth.UserID(r)
+
+	switch r.Method {
+	case http.MethodPost:
+		var req CreateInviteLinkRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		ttl := time.Duration(req.ExpiresIn) * time.Second
+		if ttl == 0 {
+			ttl = defaultInviteLinkTTL
+		}
+		if ttl < 0 || ttl > maxInviteLinkTTL {
+			http.Error(w, "expires_in must be between 1 second and 30 days", http.StatusBadRequest)
+			return
+		}
+		if req.MaxUses < 0 || req.MaxUses > maxInviteLinkUses {
+			http.Error(w, "Invalid max_uses", http.StatusBadRequest)
+			return
+		}
+		var maxUses *int
+		if req.MaxUses > 0 {
+			maxUses = &req.MaxUses
+		}
+
+		tx, _, ok := beginRoomOp(w, roomID, userID, actionManageMembers)
+		if !ok {
+			return
+		}
+		defer tx.Rollback()
+
+		token, err := newInviteToken()
+		if err != nil {
+			http.Error(w, "Failed to create invite link", http.StatusInternalServerError)
+			return
+		}
+		link, err := scanInviteLink(tx.QueryRow(`
+			INSERT INTO room_invite_links (room_id, token, created_by, expires_at, max_uses)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING `+inviteLinkColumns,
+			roomID, token, userID, time.Now().Add(ttl), maxUses))
+		if err != nil {
+			http.Error(w, "Failed to create invite link", http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Failed to create invite link", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(link)
+
+	case http.MethodGet:
+		if !requirePermission(w, userID, actionManageMembers, roomID) {
+			return
+		}
+		rows, err := db.Query(`
+			SELECT `+inviteLinkColumns+`
+			FROM room_invite_links
+			WHERE room_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+			  AND (max_uses IS NULL OR use_count < max_uses)
+			ORDER BY created_at DESC
+		`, roomID)
+		if err != nil {
+			http.Error(w, "Failed to fetch invite links", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		links := []InviteLink{}
+		for rows.Next() {
+			link, err := scanInviteLink(rows)
+			if err != nil {
+				http.Error(w, "Failed to parse invite links", http.StatusInternalServerError)
+				return
+			}
+			links = append(links, link)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, "Failed to parse invite links", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(links)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// DELETE /rooms/{id}/invite_links/{link_id}：招待リンクを無効にする
+func RevokeInviteLinkHandler(w http.ResponseWriter, r *http.Request, roomID int, linkIDStr string) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := auth.UserID(r)
+
+	linkID, err := strconv.Atoi(linkIDStr)
+	if err != nil {
+		http.Error(w, "Invalid invite link ID", http.StatusBadRequest)
+		return
+	}
+
+	tx, _, ok := beginRoomOp(w, roomID, userID, actionManageMembers)
+	if !ok {
+		return
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		UPDATE room_invite_links SET revoked_at = NOW()
+		WHERE id = $1 AND room_id = $2 AND revoked_at IS NULL
+	`, linkID, roomID)
+	if err != nil {
+		http.Error(w, "Failed to revoke invite link", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Invite link not found", http.StatusNotFound)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to revoke invite link", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 招待リンクがまだ使えるか（使えなければ理由）
+func inviteLinkUnusable(link InviteLink) string {
+	switch {
+	case link.RevokedAt != nil:
+		return "This invite link has been revoked"
+	case !time.Now().Before(link.expires):
+		return "This invite link has expired"
+	case link.MaxUses != nil && link.UseCount >= *link.MaxUses:
+		return "This invite link has reached its usage limit"
+	}
+	return ""
+}
+
+// GET /invite_links/{token}：リンク先のグループのプレビュー
+// POST /invite_links/{token}/join：リンクからグループに参加
+func InviteLinksByTokenRouter(w http.ResponseWriter, r *http.Request) {
+	token, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/invite_links/"), "/")
+	if token == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case sub == "" && r.Method == http.MethodGet:
+		previewInviteLink(w, r, token)
+	case sub == "join" && r.Method == http.MethodPost:
+		joinByInviteLink(w, r, token)
+	case sub == "" || sub == "join":
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func previewInviteLink(w http.ResponseWriter, r *http.Request, token string) {
+	userID := auth.UserID(r)
+
+	link, err := scanInviteLink(db.QueryRow(`SELECT `+inviteLinkColumns+` FROM room_invite_links WHERE token = $1`, token))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Invite link not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	if reason := inviteLinkUnusable(link); reason != "" {
+		http.Error(w, reason, http.StatusGone)
+		return
+	}
+
+	preview := InviteLinkPreview{RoomID: link.RoomID, ExpiresAt: link.ExpiresAt}
+	err = db.QueryRow(`
+		SELECT cr.room_name,
+		       (SELECT COUNT(*) FROM room_members WHERE room_id = cr.id),
+		       EXISTS (SELECT 1 FROM room_members WHERE room_id = cr.id AND user_id = $2)
+		FROM chat_rooms cr WHERE cr.id = $1
+	`, link.RoomID, userID).Scan(&preview.RoomName, &preview.MemberCount, &preview.AlreadyMember)
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(preview)
+}
+
+func joinByInviteLink(w http.ResponseWriter, r *http.Request, token string) {
+	userID := auth.UserID(r)
+
+	// ルーム → リンクの順にロックする（無効化と同じ順番）
+	var roomID int
+	err := db.QueryRow(`SELECT room_id FROM room_invite_links WHERE token = $1`, token).Scan(&roomID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Invite link not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	if err := lockGroup(tx, roomID); err != nil {
+		writeGroupError(w, err)
+		return
+	}
+
+	// 残り回数を数えるあいだに他の人が使わないよう行をロックする
+	link, err := scanInviteLink(tx.QueryRow(`SELECT `+inviteLinkColumns+` FROM room_invite_links WHERE token = $1 FOR UPDATE`, token))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Invite link not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+	if reason := inviteLinkUnusable(link); reason != "" {
+		http.Error(w, reason, http.StatusGone)
+		return
+	}
+
+	// 既にメンバーなら回数を使わない
+	joined, err := joinRoom(tx, roomID, userID)
+	if err != nil {
+		http.Error(w, "Failed to join room", http.StatusInternalServerError)
+		return
+	}
+	if joined {
+		if _, err := tx.Exec(`UPDATE room_invite_links SET use_count = use_count + 1 WHERE id = $1`, link.ID); err != nil {
+			http.Error(w, "Failed to join room", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to join room", http.StatusInternalServerError)
+		return
+	}
+
+	if joined {
+		announceJoin(roomID, userID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"room_id": roomID, "joined": joined})
+}
@@ -1,30 +0,0 @@
-package handler
-
-import (
-	"errors"
-	"net/http"
-	"strings"
-
-	"github.com/dgrijalva/jwt-go"
-)
-
-var jwtKey = []byte("your-secret-key") // ログインで使ってるやつと同じキー
-
-func GetUserIDFromToken(r *http.Request) (string, error) {
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
-		return "", errors.New("missing or invalid Authorization header")
-	}
-
-	tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
-
-	claims := &jwt.StandardClaims{}
-	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
-	if err != nil || !token.Valid {
-		return "", errors.New("invalid token")
-	}
-
-	return claims.Subject, nil // subject に username または user_id を格納していた前提
-}
@@ -4,11 +4,9 @@ import (
 	"encoding/json"
 	"fmt" // ログ出力に使う
 	"net/http"
-	"strconv"
-	"time"
 
-	"github.com/dgrijalva/jwt-go" // JWTトークン発行ライブラリ
-	"golang.org/x/crypto/bcrypt"  // パスワード照合（ハッシュ比較）ライブラリ
+	"backend/auth"               // JWTトークン発行
+	"golang.org/x/crypto/bcrypt" // パスワード照合（ハッシュ比較）ライブラリ
 )
 
 // クライアントから受け取るログイン情報（JSON形式）
@@ -51,18 +49,8 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// トークンの有効期限を24時間に設定
-	expirationTime := time.Now().Add(24 * time.Hour)
-
-	// トークンに含める情報（claims）を作成
-	claims := &jwt.StandardClaims{
-		ExpiresAt: expirationTime.Unix(), // 有効期限（UNIXタイム）
-		Subject:   strconv.Itoa(userID),  // ユーザーIDを文字列で格納
-	}
-
-	// トークンを生成（署名付き）
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey) // jwtKey は事前に定義された秘密鍵
+	// トークンを生成（subject にユーザーID、有効期限24時間）
+	tokenString, err := auth.GenerateToken(userID)
 	if err != nil {
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 		return
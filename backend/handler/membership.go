@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// ルームのメンバーでない場合のWebSocketクローズコード（HTTPの403に合わせる）
+const closeNotRoomMember = 4403
+
+// メンバーでないユーザーがルームにアクセスしようとした
+var errNotRoomMember = errors.New("not a member of this room")
+
+// ユーザーがルームのメンバーかどうか
+func isRoomMember(roomID, userID int) (bool, error) {
+	var exists bool
+	err := db.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM room_members WHERE room_id = $1 AND user_id = $2)`,
+		roomID, userID,
+	).Scan(&exists)
+	return exists, err
+}
+
+// ルームのメンバーのユーザーID一覧
+func roomMemberIDs(roomID int) ([]int, error) {
+	rows, err := db.Query(`SELECT user_id FROM room_members WHERE room_id = $1`, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, rows.Err()
+}
+
+// ユーザーが所属するルームID一覧
+func userRoomIDs(userID int) ([]int, error) {
+	rows, err := db.Query(`SELECT room_id FROM room_members WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roomIDs []int
+	for rows.Next() {
+		var roomID int
+		if err := rows.Scan(&roomID); err != nil {
+			return nil, err
+		}
+		roomIDs = append(roomIDs, roomID)
+	}
+	return roomIDs, rows.Err()
+}
+
+// メッセージの所属ルームを取得し、ユーザーがそのメンバーか確認する
+// メッセージがなければ sql.ErrNoRows、メンバーでなければ errNotRoomMember を返す
+func messageRoomForMember(messageID, userID int) (int, error) {
+	var roomID int
+	var member bool
+	err := db.QueryRow(`
+		SELECT m.room_id,
+		       EXISTS (SELECT 1 FROM room_members rm WHERE rm.room_id = m.room_id AND rm.user_id = $2)
+		FROM messages m
+		WHERE m.id = $1
+	`, messageID, userID).Scan(&roomID, &member)
+	if err != nil {
+		return 0, err
+	}
+	if !member {
+		return roomID, errNotRoomMember
+	}
+	return roomID, nil
+}
+
+// ルームのメンバーでなければ403を返す（続行してよければ true）
+func requireRoomMember(w http.ResponseWriter, roomID, userID int) bool {
+	member, err := can(userID, actionView, roomID)
+	if err != nil {
+		log.Println("❌ membership check error:", err)
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return false
+	}
+	if !member {
+		http.Error(w, "Forbidden: not a member of this room", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// メッセージが存在し、その所属ルームのメンバーであることを確認する
+// 問題があればエラーレスポンスを書いて ok=false を返す
+func requireMessageAccess(w http.ResponseWriter, messageID, userID int) (roomID int, ok bool) {
+	roomID, err := messageRoomForMember(messageID, userID)
+	switch {
+	case err == sql.ErrNoRows:
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return 0, false
+	case err == errNotRoomMember:
+		http.Error(w, "Forbidden: not a member of this room", http.StatusForbidden)
+		return 0, false
+	case err != nil:
+		log.Println("❌ membership check error:", err)
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return 0, false
+	}
+	return roomID, true
+}
@@ -9,13 +9,26 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"backend/auth"
 )
 
 // 📦 クライアントから受け取るメッセージ構造体（POST時）
 type Message struct {
 	RoomID   int    `json:"room_id"`   // 送信先ルームID
-	SenderID int    `json:"sender_id"` // 送信者ユーザーID
+	SenderID int    `json:"sender_id"` // 送信者ユーザーID（保存時はトークンのユーザーで上書き）
 	Content  string `json:"content"`   // メッセージ内容
+	ParentID *int   `json:"parent_id"` // スレッドの返信先（親メッセージID）
+
+	ReplyToMessageID *int  `json:"reply_to_message_id"` // 引用返信する元メッセージID
+	AttachmentIDs    []int `json:"attachment_ids"`      // POST /upload で受け取った添付ID
+
+	// 転送元（ForwardMessageHandler だけが設定する）
+	ForwardedFromMessageID *int `json:"-"`
+	ForwardedFromUserID    *int `json:"-"`
+
+	// ルーム操作の記録（postSystemMessage だけが設定する）
+	System *SystemEvent `json:"-"`
 }
 
 // 📤 クライアントに返すメッセージ構造体（GET・POSTのレスポンス）
@@ -28,71 +41,147 @@ type MessageResponse struct {
 	ReadBy    []int  `json:"read_by"`    // 既読ユーザーのID配列
 	Edited    bool   `json:"edited"`     // 👈 編集されたかどうか
 	IsDeleted bool   `json:"is_deleted"` // 👈 削除されたかどうか
+	Revision  int    `json:"revision"`   // 編集回数（GET /messages/{id}/revisions で履歴を取れる）
+
+	Reactions   []ReactionSummary `json:"reactions"`   // 絵文字リアクションの集計
+	Attachments []Attachment      `json:"attachments"` // 添付ファイル
+
+	// スレッド
+	ParentID          *int    `json:"parent_id,omitempty"` // 返信の場合の親メッセージID
+	ReplyCount        int     `json:"reply_count"`         // 親メッセージへの返信数
+	LastReplyAt       *string `json:"last_reply_at"`       // 最後の返信日時
+	ThreadUnreadCount int     `json:"thread_unread_count"` // 自分が未読の返信数
+
+	// ピン留め
+	Pinned   bool    `json:"pinned"`
+	PinnedBy *int    `json:"pinned_by,omitempty"`
+	PinnedAt *string `json:"pinned_at,omitempty"`
+
+	ReplyTo       *QuotedMessage `json:"reply_to,omitempty"`       // 引用返信の元メッセージ
+	ForwardedFrom *ForwardInfo   `json:"forwarded_from,omitempty"` // 転送元
+	System        *SystemEvent   `json:"system,omitempty"`         // システムメッセージ（名前変更・メンバー追加など）の内容
+
+	replyToID           *int // fillQuotes が ReplyTo を作るための元メッセージID
+	forwardedFromUserID *int // fillQuotes が ForwardedFrom を作るための転送元ユーザーID
+}
+
+// WebSocketで配信するメッセージイベント（MessageResponse の各フィールドに type を付けたもの）
+type MessageEvent struct {
+	Type string `json:"type"`
+	MessageResponse
 }
 
+var mentionRegex = regexp.MustCompile(`@(\w+)`)
+
+// 本文から @ユーザー名 を抽出
 func extractMentions(content string) []string {
-	words := strings.Fields(content)
 	var mentions []string
-	for _, word := range words {
-		if strings.HasPrefix(word, "@") {
-			mentions = append(mentions, strings.TrimPrefix(word, "@"))
+	for _, match := range mentionRegex.FindAllStringSubmatch(content, -1) {
+		if len(match) < 2 {
+			continue
 		}
+		mentions = append(mentions, match[1])
 	}
 	return mentions
 }
 
-// ------------------------------
-// 📮 メッセージ保存処理（POST）
-// ------------------------------
-func SendMessageHandler(w http.ResponseWriter, r *http.Request) {
-	userIDStr, err := GetUserIDFromToken(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-	userID, _ := strconv.Atoi(userIDStr)
+// メッセージをDBに保存し、メンションを記録・通知する（HTTPとWebSocketの共通処理）
+// 返信（ParentID あり）の場合は親が同じルームのスレッド親であることを確認する
+func createMessage(m Message) (MessageResponse, error) {
+	roomID, senderID, content := m.RoomID, m.SenderID, m.Content
 
-	var msg struct {
-		RoomID  int    `json:"room_id"`
-		Content string `json:"content"`
+	if m.ParentID != nil {
+		if err := validateThreadParent(roomID, *m.ParentID); err != nil {
+			return MessageResponse{}, err
+		}
 	}
-	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
-		http.Error(w, "Invalid input", http.StatusBadRequest)
-		return
+	if m.ReplyToMessageID != nil {
+		if err := validateQuotedMessage(roomID, *m.ReplyToMessageID); err != nil {
+			return MessageResponse{}, err
+		}
 	}
-	fmt.Println("📩 メッセージ内容:", msg.Content)
 
-	query := `INSERT INTO messages (room_id, sender_id, content, created_at) 
-				VALUES ($1, $2, $3, NOW()) RETURNING id, created_at`
+	// メッセージと添付の紐づけは一緒に確定させる
+	tx, err := db.Begin()
+	if err != nil {
+		return MessageResponse{}, err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO messages (room_id, sender_id, content, parent_id, reply_to_message_id,
+				forwarded_from_message_id, forwarded_from_user_id, system_event, created_at) 
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW()) RETURNING id, created_at`
+
+	var systemEvent []byte // NULL ならふつうのメッセージ
+	if m.System != nil {
+		if systemEvent, err = json.Marshal(m.System); err != nil {
+			return MessageResponse{}, err
+		}
+	}
 
 	var messageID int
 	var createdAt time.Time
+	err = tx.QueryRow(query, roomID, senderID, content, m.ParentID, m.ReplyToMessageID,
+		m.ForwardedFromMessageID, m.ForwardedFromUserID, systemEvent).Scan(&messageID, &createdAt)
+	if err != nil {
+		return MessageResponse{}, err
+	}
 
-	err = db.QueryRow(query, msg.RoomID, userID, msg.Content).Scan(&messageID, &createdAt)
+	if len(m.AttachmentIDs) > 0 {
+		if err := linkAttachments(tx, messageID, senderID, m.AttachmentIDs); err != nil {
+			return MessageResponse{}, err
+		}
+	}
+	if m.ForwardedFromMessageID != nil {
+		if err := copyAttachments(tx, *m.ForwardedFromMessageID, messageID, senderID); err != nil {
+			return MessageResponse{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return MessageResponse{}, err
+	}
+
+	// 自分の送ったメッセージまでは既読扱い（返信はスレッドの既読位置を進める）
+	if m.ParentID != nil {
+		_, err = markThreadReadUpTo(*m.ParentID, senderID, messageID)
+	} else {
+		_, err = markReadUpTo(roomID, senderID, messageID)
+	}
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error sending message: %s", err), http.StatusInternalServerError)
-		return
+		log.Println("❌ 既読位置の更新失敗:", err)
 	}
 
 	res := MessageResponse{
-		ID:        messageID,
-		RoomID:    msg.RoomID,
-		SenderID:  userID, // ← tokenから取得した値！
-		Content:   msg.Content,
-		CreatedAt: createdAt.Format(time.RFC3339),
-		ReadBy:    []int{},
+		ID:          messageID,
+		RoomID:      roomID,
+		SenderID:    senderID,
+		Content:     content,
+		CreatedAt:   createdAt.Format(time.RFC3339),
+		ReadBy:      []int{},
+		Reactions:   []ReactionSummary{},
+		Attachments: []Attachment{},
+		ParentID:    m.ParentID,
+		System:      m.System,
 	}
-	// --- メンション処理（@ユーザー名 抽出） ---
 
-	mentionRegex := regexp.MustCompile(`@(\w+)`)
-	matches := mentionRegex.FindAllStringSubmatch(msg.Content, -1)
+	// 引用・転送元・添付の情報を付ける
+	res.replyToID, res.forwardedFromUserID = m.ReplyToMessageID, m.ForwardedFromUserID
+	created := []MessageResponse{res}
+	if err := fillQuotes(created); err != nil {
+		log.Println("❌ 引用情報の取得失敗:", err)
+	}
+	if err := fillAttachments(created); err != nil {
+		log.Println("❌ 添付情報の取得失敗:", err)
+	}
+	res = created[0]
 
-	for _, match := range matches {
-		if len(match) < 2 {
-			continue
-		}
-		username := match[1]
+	// システムメッセージにはメンションがない。転送は元の本文のコピーなので、改めて通知しない
+	if m.System != nil || m.ForwardedFromMessageID != nil {
+		return res, nil
+	}
 
+	// --- メンション処理（@ユーザー名 抽出） ---
+	for _, username := range extractMentions(content) {
 		// ユーザー名からユーザーID取得
 		var mentionedUserID int
 		err := db.QueryRow(`SELECT id FROM users WHERE username = $1`, username).Scan(&mentionedUserID)
@@ -100,22 +189,71 @@ func SendMessageHandler(w http.ResponseWriter, r *http.Request) {
 			continue // ユーザーが見つからなければスキップ
 		}
 
-		// mentions テーブルに保存
+		// ルームのメンバー以外には保存も通知もしない（本文が外に漏れないように）
+		member, err := isRoomMember(roomID, mentionedUserID)
+		if err != nil {
+			log.Println("❌ mention membership check error:", err)
+			continue
+		}
+		if !member {
+			continue
+		}
 
+		// mentions テーブルに保存
 		_, err = db.Exec(`
 	INSERT INTO mentions (message_id, mention_target_id)
 	VALUES ($1, $2) ON CONFLICT DO NOTHING
 `, messageID, mentionedUserID)
-
 		if err != nil {
 			fmt.Println("❌ mention insert error:", err)
 		}
+
 		// WebSocket通知（自分以外）
-		if mentionedUserID != userID {
-			BroadcastMentionNotification(msg.RoomID, mentionedUserID, userID, msg.Content)
+		if mentionedUserID != senderID {
+			BroadcastMentionNotification(roomID, mentionedUserID, senderID, content)
 		}
+	}
+
+	return res, nil
+}
+
+// ------------------------------
+// 📮 メッセージ保存処理（POST）
+// ------------------------------
+func SendMessageHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserID(r)
+
+	var msg Message
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	msg.SenderID = userID
+	fmt.Println("📩 メッセージ内容:", msg.Content)
+
+	// 本文か添付のどちらかは必要
+	if strings.TrimSpace(msg.Content) == "" && len(msg.AttachmentIDs) == 0 {
+		http.Error(w, "content or attachment_ids is required", http.StatusBadRequest)
+		return
+	}
+
+	// 送信できる役割のメンバーだけ（読み取り専用は不可）
+	if !requirePermission(w, userID, actionSend, msg.RoomID) {
+		return
+	}
 
+	res, err := createMessage(msg)
+	if err == errInvalidThreadParent || err == errInvalidQuote || err == errInvalidAttachment {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error sending message: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	// 送信者以外もリアルタイムに受け取れるよう配信
+	broadcastNewMessage(res, nil)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(res)
@@ -123,73 +261,77 @@ func SendMessageHandler(w http.ResponseWriter, r *http.Request) {
 
 // ------------------------------
 // 📥 メッセージ取得処理（GET）
+// ?room_id=○ に加えて before / after / around（メッセージID）と limit を指定できる
 // ------------------------------
 func GetMessagesHandler(w http.ResponseWriter, r *http.Request) {
-	roomID := r.URL.Query().Get("room_id")
-	if roomID == "" {
+	roomIDStr := r.URL.Query().Get("room_id")
+	if roomIDStr == "" {
 		http.Error(w, "Missing room_id", http.StatusBadRequest)
 		return
 	}
-
-	userIDStr, err := GetUserIDFromToken(r)
+	roomID, err := strconv.Atoi(roomIDStr)
 	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		http.Error(w, "Invalid room_id", http.StatusBadRequest)
 		return
 	}
-	userID, _ := strconv.Atoi(userIDStr)
 
-	query := `
-	SELECT id, room_id, sender_id, content, created_at, edited_at, is_deleted
-	FROM messages 
-	WHERE room_id = $1 AND NOT ($2 = ANY(hidden_user_ids))
-	ORDER BY created_at ASC`
-	rows, err := db.Query(query, roomID, userID)
+	userID := auth.UserID(r)
 
+	if !requireRoomMember(w, roomID, userID) {
+		return
+	}
+
+	// before / after / around / limit でページング
+	params, err := parseMessagePageParams(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := fetchMessagesPage(roomID, 0, userID, params)
 	if err != nil {
 		http.Error(w, "Failed to fetch messages", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
 
-	var messages []MessageResponse
-	for rows.Next() {
-		var (
-			msg       MessageResponse
-			createdAt time.Time
-			editedAt  *time.Time
-		)
+	// 既読ユーザーID一覧（メンバーの既読位置から計算）
+	if err := fillReadBy(roomID, page.Messages); err != nil {
+		http.Error(w, "Failed to fetch read_by", http.StatusInternalServerError)
+		return
+	}
 
-		// ここで edited_at と is_deleted も含めてスキャン
-		if err := rows.Scan(&msg.ID, &msg.RoomID, &msg.SenderID, &msg.Content, &createdAt, &editedAt, &msg.IsDeleted); err != nil {
-			http.Error(w, "Failed to parse messages", http.StatusInternalServerError)
-			return
-		}
-		msg.CreatedAt = createdAt.Format(time.RFC3339)
-		msg.Edited = (editedAt != nil) // 編集されたかどうかの判定
+	// リアクションの集計
+	if err := fillReactions(userID, page.Messages); err != nil {
+		http.Error(w, "Failed to fetch reactions", http.StatusInternalServerError)
+		return
+	}
 
-		msg.ReadBy = []int{}
+	// スレッドの返信数・最終返信日時・未読数
+	if err := fillThreadSummaries(userID, page.Messages); err != nil {
+		http.Error(w, "Failed to fetch threads", http.StatusInternalServerError)
+		return
+	}
 
-		// 既読ユーザーID一覧の取得
-		readQuery := `SELECT user_id FROM message_reads WHERE message_id = $1`
-		readRows, err := db.Query(readQuery, msg.ID)
-		if err != nil {
-			http.Error(w, "Failed to fetch read_by", http.StatusInternalServerError)
-			return
-		}
-		defer readRows.Close()
+	// 引用元・転送元
+	if err := fillQuotes(page.Messages); err != nil {
+		http.Error(w, "Failed to fetch quotes", http.StatusInternalServerError)
+		return
+	}
 
-		for readRows.Next() {
-			var uid int
-			if err := readRows.Scan(&uid); err == nil {
-				msg.ReadBy = append(msg.ReadBy, uid)
-			}
-		}
+	// ピン留め状態
+	if err := fillPins(page.Messages); err != nil {
+		http.Error(w, "Failed to fetch pins", http.StatusInternalServerError)
+		return
+	}
 
-		messages = append(messages, msg)
+	// 添付ファイル
+	if err := fillAttachments(page.Messages); err != nil {
+		http.Error(w, "Failed to fetch attachments", http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(messages)
+	json.NewEncoder(w).Encode(page)
 }
 
 // ← 今これがないので、新しく作ろう！
@@ -205,10 +347,39 @@ func MessagesRouter(w http.ResponseWriter, r *http.Request) {
 }
 
 // ------------------------------
-// 🌐 POST / GET を切り分けるルーター
+// 🌐 /messages/{id} 以下を切り分けるルーター
 // ------------------------------
 func MessagesByIDRouter(w http.ResponseWriter, r *http.Request) {
-	idStr := strings.TrimPrefix(r.URL.Path, "/messages/")
+	idStr, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/messages/"), "/")
+
+	switch sub {
+	case "":
+		// /messages/{id}
+	case "hide":
+		HideMessageForUser(w, r)
+		return
+	case "reactions":
+		MessageReactionsHandler(w, r, idStr)
+		return
+	case "replies":
+		ThreadRepliesHandler(w, r, idStr)
+		return
+	case "replies/read":
+		MarkThreadReadHandler(w, r, idStr)
+		return
+	case "forward":
+		ForwardMessageHandler(w, r, idStr)
+		return
+	case "pin":
+		PinMessageHandler(w, r, idStr)
+		return
+	case "revisions":
+		MessageRevisionsHandler(w, r, idStr)
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
 
 	switch r.Method {
 	case http.MethodPut:
@@ -221,12 +392,7 @@ func MessagesByIDRouter(w http.ResponseWriter, r *http.Request) {
 }
 
 func EditMessageHandler(w http.ResponseWriter, r *http.Request, messageIDStr string) {
-	userIDStr, err := GetUserIDFromToken(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-	userID, _ := strconv.Atoi(userIDStr)
+	userID := auth.UserID(r)
 
 	messageID, err := strconv.Atoi(messageIDStr)
 	if err != nil {
@@ -234,6 +400,15 @@ func EditMessageHandler(w http.ResponseWriter, r *http.Request, messageIDStr str
 		return
 	}
 
+	roomID, ok := requireMessageAccess(w, messageID, userID)
+	if !ok {
+		return
+	}
+	// 読み取り専用になったメンバーは自分のメッセージも編集できない
+	if !requirePermission(w, userID, actionSend, roomID) {
+		return
+	}
+
 	var input struct {
 		Content string `json:"content"`
 	}
@@ -242,13 +417,15 @@ func EditMessageHandler(w http.ResponseWriter, r *http.Request, messageIDStr str
 		return
 	}
 
-	query := `
-		UPDATE messages 
-		SET content = $1, edited_at = NOW()
-		WHERE id = $2 AND sender_id = $3
-	`
-	_, err = db.Exec(query, input.Content, messageID, userID)
-	if err != nil {
+	revision, _, err := editMessage(messageID, userID, input.Content)
+	switch {
+	case err == errNotMessageSender:
+		http.Error(w, "You can only edit your own messages", http.StatusForbidden)
+		return
+	case err == errMessageDeleted:
+		http.Error(w, "Message is deleted", http.StatusConflict)
+		return
+	case err != nil:
 		http.Error(w, "Failed to update message", http.StatusInternalServerError)
 		return
 	}
@@ -260,9 +437,8 @@ func EditMessageHandler(w http.ResponseWriter, r *http.Request, messageIDStr str
 	var createdAt time.Time
 	var editedAt *time.Time
 	var isDeleted bool
-	var roomID int
-	err = db.QueryRow(`SELECT room_id, sender_id, content, created_at, edited_at, is_deleted FROM messages WHERE id = $1`, messageID).
-		Scan(&roomID, &updatedMsg.SenderID, &updatedMsg.Content, &createdAt, &editedAt, &isDeleted)
+	err = db.QueryRow(`SELECT sender_id, content, created_at, edited_at, is_deleted FROM messages WHERE id = $1`, messageID).
+		Scan(&updatedMsg.SenderID, &updatedMsg.Content, &createdAt, &editedAt, &isDeleted)
 	if err != nil {
 		log.Println("❌ メッセージ取得失敗:", err)
 	} else {
@@ -271,11 +447,16 @@ func EditMessageHandler(w http.ResponseWriter, r *http.Request, messageIDStr str
 		updatedMsg.CreatedAt = createdAt.Format(time.RFC3339)
 		updatedMsg.Edited = editedAt != nil
 		updatedMsg.IsDeleted = isDeleted
+		updatedMsg.Revision = revision
 		updatedMsg.ReadBy = []int{} // クライアントで保持しているので空でOK
+		updatedMsg.Reactions = []ReactionSummary{}
+		updatedMsg.Attachments = []Attachment{}
 
+		// revision を見て、追い越された古い編集イベントはクライアント側で捨てられる
 		BroadcastToRoom(roomID, map[string]interface{}{
-			"type":    "edit_message",
-			"message": updatedMsg,
+			"type":     "edit_message",
+			"revision": revision,
+			"message":  updatedMsg,
 		})
 	}
 	log.Println("🔊 Broadcasting edited message to room:", roomID)
@@ -285,12 +466,7 @@ func EditMessageHandler(w http.ResponseWriter, r *http.Request, messageIDStr str
 }
 
 func DeleteMessageHandler(w http.ResponseWriter, r *http.Request, messageIDStr string) {
-	userIDStr, err := GetUserIDFromToken(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-	userID, _ := strconv.Atoi(userIDStr)
+	userID := auth.UserID(r)
 
 	messageID, err := strconv.Atoi(messageIDStr)
 	if err != nil {
@@ -298,23 +474,49 @@ func DeleteMessageHandler(w http.ResponseWriter, r *http.Request, messageIDStr s
 		return
 	}
 
-	query := `
-		UPDATE messages 
-		SET is_deleted = TRUE
-		WHERE id = $1 AND sender_id = $2
-	`
-	_, err = db.Exec(query, messageID, userID)
+	roomID, ok := requireMessageAccess(w, messageID, userID)
+	if !ok {
+		return
+	}
+
+	// 管理者は他人のメッセージも削除できる
+	moderator, err := can(userID, actionModerate, roomID)
 	if err != nil {
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return
+	}
+
+	storageKeys, pinned, err := unsendMessage(messageID, userID, moderator)
+	switch {
+	case err == errNotMessageSender:
+		http.Error(w, "You can only unsend your own messages", http.StatusForbidden)
+		return
+	case err == errUnsendWindowExpired:
+		http.Error(w, "This message can no longer be unsent", http.StatusForbidden)
+		return
+	case err == errMessageDeleted:
+		// 取消済みなら何もしない
+		w.WriteHeader(http.StatusOK)
+		return
+	case err != nil:
 		http.Error(w, "Failed to delete message", http.StatusInternalServerError)
 		return
 	}
-	// 削除対象のメッセージのroom_idを取得
-	var roomID int
-	err = db.QueryRow(`SELECT room_id FROM messages WHERE id = $1`, messageID).Scan(&roomID)
-	if err == nil {
+	for _, key := range storageKeys {
+		removeUnusedUpload(key)
+	}
+
+	BroadcastToRoom(roomID, map[string]interface{}{
+		"type":       "delete_message",
+		"message_id": messageID,
+		"deleted_by": userID,
+	})
+	if pinned {
 		BroadcastToRoom(roomID, map[string]interface{}{
-			"type":       "delete_message",
+			"type":       "unpin",
+			"room_id":    roomID,
 			"message_id": messageID,
+			"user_id":    userID,
 		})
 	}
 
@@ -324,13 +526,8 @@ func DeleteMessageHandler(w http.ResponseWriter, r *http.Request, messageIDStr s
 // 🚨 新しく追加するエンドポイント
 // POST /messages/{id}/hide
 func HideMessageForUser(w http.ResponseWriter, r *http.Request) {
-	// JWTからuserIDを取得
-	userIDStr, err := GetUserIDFromToken(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-	userID, _ := strconv.Atoi(userIDStr)
+	// ログイン中のユーザーID
+	userID := auth.UserID(r)
 
 	// メッセージIDをURLから取得
 	messageIDStr := strings.TrimPrefix(r.URL.Path, "/messages/")
@@ -341,6 +538,11 @@ func HideMessageForUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	roomID, ok := requireMessageAccess(w, messageID, userID)
+	if !ok {
+		return
+	}
+
 	// hidden_user_ids に userID を追加（重複しないように）
 	_, err = db.Exec(`
 		UPDATE messages 
@@ -353,15 +555,11 @@ func HideMessageForUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// WebSocketで通知（必要に応じて）
-	var roomID int
-	err = db.QueryRow(`SELECT room_id FROM messages WHERE id = $1`, messageID).Scan(&roomID)
-	if err == nil {
-		BroadcastToRoom(roomID, map[string]interface{}{
-			"type":       "hide_message",
-			"message_id": messageID,
-			"user_id":    userID,
-		})
-	}
+	BroadcastToRoom(roomID, map[string]interface{}{
+		"type":       "hide_message",
+		"message_id": messageID,
+		"user_id":    userID,
+	})
 
 	w.WriteHeader(http.StatusNoContent)
 }
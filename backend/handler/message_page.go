@@ -0,0 +1,225 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// 1ページあたりの件数（limit 未指定時と上限）
+const (
+	defaultMessageLimit = 50
+	maxMessageLimit     = 200
+)
+
+// GET /messages のレスポンス（メッセージ本体＋ページング情報）
+type MessagesPage struct {
+	Messages      []MessageResponse `json:"messages"`        // 古い順
+	HasMoreBefore bool              `json:"has_more_before"` // さらに古いメッセージがあるか
+	HasMoreAfter  bool              `json:"has_more_after"`  // さらに新しいメッセージがあるか
+	PrevCursor    *int              `json:"prev_cursor"`     // 次に古いページを取るときの before（最古のID）
+	NextCursor    *int              `json:"next_cursor"`     // 次に新しいページを取るときの after（最新のID）
+}
+
+// クエリパラメータから取り出したページ指定
+type messagePageParams struct {
+	before int // このIDより古いもの
+	after  int // このIDより新しいもの
+	around int // このIDの前後
+	limit  int
+}
+
+// before / after / around / limit をパース（before・after・around は同時に1つまで）
+func parseMessagePageParams(q url.Values) (messagePageParams, error) {
+	p := messagePageParams{limit: defaultMessageLimit}
+
+	ids := map[string]*int{"before": &p.before, "after": &p.after, "around": &p.around}
+	given := 0
+	for key, dst := range ids {
+		v := q.Get(key)
+		if v == "" {
+			continue
+		}
+		id, err := strconv.Atoi(v)
+		if err != nil || id <= 0 {
+			return p, errors.New("invalid " + key)
+		}
+		*dst = id
+		given++
+	}
+	if given > 1 {
+		return p, errors.New("before, after and around cannot be combined")
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return p, errors.New("invalid limit")
+		}
+		if limit > maxMessageLimit {
+			limit = maxMessageLimit
+		}
+		p.limit = limit
+	}
+	return p, nil
+}
+
+// ルームのメッセージを1ページ分取得（非表示にしたメッセージは除く）
+// parentID が0ならメインのタイムライン（返信以外）、それ以外はそのスレッドの返信
+func fetchMessagesPage(roomID, parentID, userID int, p messagePageParams) (MessagesPage, error) {
+	scope := messageScope{roomID: roomID, parentID: parentID}
+	var page MessagesPage
+	var err error
+
+	switch {
+	case p.after > 0:
+		// 指定IDより新しいものを古い順に
+		page.Messages, page.HasMoreAfter, err = queryMessageRange(scope, userID, "m.id > $3", "ASC", p.after, p.limit)
+		if err != nil {
+			return page, err
+		}
+		// 指定IDが非表示・削除済みだったり最初のメッセージだったりしても正しく判定する
+		page.HasMoreBefore, err = messagesExist(scope, userID, "m.id <= $3", p.after)
+
+	case p.around > 0:
+		// 指定IDより前を半分、指定ID以降を残り（limit=1 なら前は0件だが、あるかどうかは調べる）
+		olderLimit := p.limit / 2
+		var older, newer []MessageResponse
+		older, page.HasMoreBefore, err = queryMessageRange(scope, userID, "m.id < $3", "DESC", p.around, olderLimit)
+		if err != nil {
+			return page, err
+		}
+		newer, page.HasMoreAfter, err = queryMessageRange(scope, userID, "m.id >= $3", "ASC", p.around, p.limit-olderLimit)
+		reverseMessages(older)
+		page.Messages = append(older, newer...)
+
+	case p.before > 0:
+		// 指定IDより古いものを新しい順に取ってから並べ直す
+		page.Messages, page.HasMoreBefore, err = queryMessageRange(scope, userID, "m.id < $3", "DESC", p.before, p.limit)
+		if err != nil {
+			return page, err
+		}
+		reverseMessages(page.Messages)
+		page.HasMoreAfter, err = messagesExist(scope, userID, "m.id >= $3", p.before)
+
+	default:
+		// 最新のページ
+		page.Messages, page.HasMoreBefore, err = queryMessageRange(scope, userID, "$3 = 0", "DESC", 0, p.limit)
+		reverseMessages(page.Messages)
+	}
+	if err != nil {
+		return page, err
+	}
+
+	if page.Messages == nil {
+		page.Messages = []MessageResponse{}
+	}
+	if n := len(page.Messages); n > 0 {
+		oldest, newest := page.Messages[0].ID, page.Messages[n-1].ID
+		page.PrevCursor = &oldest
+		page.NextCursor = &newest
+	}
+	return page, nil
+}
+
+// メッセージ一覧のSELECT句（scanMessages と並びを合わせる）
+const messageColumns = `m.id, m.room_id, m.sender_id, m.content, m.created_at, m.edited_at, m.is_deleted, m.parent_id,
+	       m.reply_to_message_id, m.forwarded_from_user_id, m.revision, m.system_event`
+
+// messageColumns の行を MessageResponse に読み込む
+func scanMessages(rows *sql.Rows) ([]MessageResponse, error) {
+	var messages []MessageResponse
+	for rows.Next() {
+		var (
+			msg         MessageResponse
+			createdAt   time.Time
+			editedAt    *time.Time
+			systemEvent []byte
+		)
+		if err := rows.Scan(&msg.ID, &msg.RoomID, &msg.SenderID, &msg.Content, &createdAt, &editedAt, &msg.IsDeleted, &msg.ParentID,
+			&msg.replyToID, &msg.forwardedFromUserID, &msg.Revision, &systemEvent); err != nil {
+			return nil, err
+		}
+		if systemEvent != nil {
+			msg.System = &SystemEvent{}
+			if err := json.Unmarshal(systemEvent, msg.System); err != nil {
+				return nil, err
+			}
+		}
+		msg.CreatedAt = createdAt.Format(time.RFC3339)
+		msg.Edited = (editedAt != nil) // 編集されたかどうかの判定
+		msg.ReadBy = []int{}
+		msg.Reactions = []ReactionSummary{}
+		msg.Attachments = []Attachment{}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// 取得対象（ルームのメインタイムライン、またはスレッド）
+type messageScope struct {
+	roomID   int
+	parentID int // 0ならメインタイムライン
+}
+
+// 取得対象のうち、ユーザーに見えるメッセージに絞る WHERE 条件
+// $1 がルーム、$2 がユーザー、$3 がカーソル、$5 が親メッセージ。cond は "$3" を使う固定文字列のみ
+func messageRangeWhere(scope messageScope, cond string) string {
+	scopeCond := "m.parent_id IS NULL AND $5 = 0"
+	if scope.parentID != 0 {
+		scopeCond = "m.parent_id = $5"
+	}
+	return "m.room_id = $1 AND NOT ($2 = ANY(m.hidden_user_ids)) AND " + scopeCond + " AND " + cond
+}
+
+// 条件に合うメッセージが1件でもあるか（ページの外側に続きがあるかの判定用）
+func messagesExist(scope messageScope, userID int, cond string, cursor int) (bool, error) {
+	// 引数の番号を queryMessageRange と揃えるため、使わない $4 も渡す
+	var exists bool
+	err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM messages m WHERE `+messageRangeWhere(scope, cond)+` AND $4 = 0)`,
+		scope.roomID, userID, cursor, 0, scope.parentID).Scan(&exists)
+	return exists, err
+}
+
+// 条件に合うメッセージを limit 件取得し、まだ続きがあるかを返す（limit が0なら続きの有無だけ調べる）
+// cond は "$3" をカーソルとして使う WHERE 条件（呼び出し側の固定文字列のみ）
+func queryMessageRange(scope messageScope, userID int, cond, order string, cursor, limit int) ([]MessageResponse, bool, error) {
+	if limit <= 0 {
+		exists, err := messagesExist(scope, userID, cond, cursor)
+		return nil, exists, err
+	}
+
+	query := `
+	SELECT ` + messageColumns + `
+	FROM messages m
+	WHERE ` + messageRangeWhere(scope, cond) + `
+	ORDER BY m.id ` + order + `
+	LIMIT $4`
+	// 1件多く取って続きがあるか判定する
+	rows, err := db.Query(query, scope.roomID, userID, cursor, limit+1, scope.parentID)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	messages, err := scanMessages(rows)
+	if err != nil {
+		return nil, false, err
+	}
+
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+	return messages, hasMore, nil
+}
+
+// スライスの並びを反転（新しい順→古い順）
+func reverseMessages(messages []MessageResponse) {
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+}
@@ -0,0 +1,167 @@
+package handler
+
+import (
+	"log"
+)
+
+// 起動時に流すスキーマ変更（何度実行しても同じ結果になるものだけを書く）
+// 既存テーブル（users, chat_rooms, room_members, messages など）は作成済みの前提
+var migrations = []string{
+	// --- 既読ウォーターマーク（メンバーごとの「ここまで読んだ」メッセージID） ---
+	`ALTER TABLE room_members ADD COLUMN IF NOT EXISTS last_read_message_id INTEGER NOT NULL DEFAULT 0`,
+	// message_reads の既存データから初期値を作る
+	`UPDATE room_members rm
+	 SET last_read_message_id = r.max_id
+	 FROM (
+	   SELECT m.room_id, mr.user_id, MAX(m.id) AS max_id
+	   FROM message_reads mr
+	   JOIN messages m ON m.id = mr.message_id
+	   GROUP BY m.room_id, mr.user_id
+	 ) r
+	 WHERE rm.room_id = r.room_id AND rm.user_id = r.user_id AND rm.last_read_message_id < r.max_id`,
+
+	// --- プレゼンス（最終接続時刻） ---
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMPTZ`,
+
+	// --- 絵文字リアクション（1人が同じ絵文字を付けられるのは1回） ---
+	`CREATE TABLE IF NOT EXISTS message_reactions (
+		message_id INTEGER NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+		user_id    INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		emoji      TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (message_id, user_id, emoji)
+	)`,
+
+	// --- スレッド返信（親メッセージへの参照と、スレッドごとの既読位置） ---
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS parent_id INTEGER REFERENCES messages(id) ON DELETE CASCADE`,
+	`CREATE INDEX IF NOT EXISTS messages_parent_id_idx ON messages (parent_id, id) WHERE parent_id IS NOT NULL`,
+	`CREATE TABLE IF NOT EXISTS thread_reads (
+		parent_id          INTEGER NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+		user_id            INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		last_read_reply_id INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (parent_id, user_id)
+	)`,
+
+	// --- 引用返信と転送（元メッセージが消えても参照だけ外す） ---
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS reply_to_message_id INTEGER REFERENCES messages(id) ON DELETE SET NULL`,
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS forwarded_from_message_id INTEGER REFERENCES messages(id) ON DELETE SET NULL`,
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS forwarded_from_user_id INTEGER REFERENCES users(id) ON DELETE SET NULL`,
+
+	// --- ピン留め（1メッセージにつき1件） ---
+	`CREATE TABLE IF NOT EXISTS pinned_messages (
+		message_id INTEGER PRIMARY KEY REFERENCES messages(id) ON DELETE CASCADE,
+		room_id    INTEGER NOT NULL REFERENCES chat_rooms(id) ON DELETE CASCADE,
+		pinned_by  INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		pinned_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS pinned_messages_room_idx ON pinned_messages (room_id, pinned_at DESC)`,
+
+	// --- メッセージ検索（単語の全文検索と、日本語向けの部分一致用トライグラム） ---
+	`CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+	`CREATE INDEX IF NOT EXISTS messages_content_fts_idx ON messages USING GIN (to_tsvector('simple', content))`,
+	`CREATE INDEX IF NOT EXISTS messages_content_trgm_idx ON messages USING GIN (content gin_trgm_ops)`,
+
+	// --- 編集履歴（編集前の本文を版ごとに残す。messages.revision は現在の版番号＝編集回数） ---
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS revision INTEGER NOT NULL DEFAULT 0`,
+	`CREATE TABLE IF NOT EXISTS message_revisions (
+		message_id INTEGER NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+		revision   INTEGER NOT NULL,
+		content    TEXT NOT NULL,
+		edited_by  INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		created_at TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (message_id, revision)
+	)`,
+
+	// --- 添付ファイル（storage_key は中身の sha256＋拡張子。同じ中身は同じファイルを共有） ---
+	`CREATE TABLE IF NOT EXISTS attachments (
+		id          SERIAL PRIMARY KEY,
+		uploader_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		message_id  INTEGER REFERENCES messages(id) ON DELETE CASCADE,
+		storage_key TEXT NOT NULL,
+		filename    TEXT NOT NULL,
+		mime_type   TEXT NOT NULL,
+		size        BIGINT NOT NULL,
+		width       INTEGER,
+		height      INTEGER,
+		created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`,
+	`CREATE INDEX IF NOT EXISTS attachments_message_id_idx ON attachments (message_id) WHERE message_id IS NOT NULL`,
+	`CREATE INDEX IF NOT EXISTS attachments_storage_key_idx ON attachments (storage_key)`,
+
+	// --- 画像のサムネイル（[{size, key, width, height}]） ---
+	`ALTER TABLE attachments ADD COLUMN IF NOT EXISTS thumbnails JSONB NOT NULL DEFAULT '[]'`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS profile_image_thumbnails JSONB NOT NULL DEFAULT '[]'`,
+
+	// --- プロフィールの日時 ---
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ`,
+
+	// --- グループ管理（システムメッセージの内容と、参加日時＝オーナー引き継ぎの順番） ---
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS system_event JSONB`,
+	`ALTER TABLE room_members ADD COLUMN IF NOT EXISTS joined_at TIMESTAMPTZ NOT NULL DEFAULT NOW()`,
+
+	// --- ルームごとの役割（owner / admin / member / read_only） ---
+	`ALTER TABLE room_members ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'member'
+		CHECK (role IN ('owner', 'admin', 'member', 'read_only'))`,
+	// 既存グループは作成者をオーナーにする
+	`UPDATE room_members rm SET role = 'owner'
+	 FROM chat_rooms cr
+	 WHERE cr.id = rm.room_id AND cr.is_group AND cr.created_by = rm.user_id AND rm.role = 'member'`,
+
+	// --- グループへの招待（承諾するとメンバーになる） ---
+	`CREATE TABLE IF NOT EXISTS room_invitations (
+		id           SERIAL PRIMARY KEY,
+		room_id      INTEGER NOT NULL REFERENCES chat_rooms(id) ON DELETE CASCADE,
+		inviter_id   INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		invitee_id   INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		status       TEXT NOT NULL DEFAULT 'pending'
+		             CHECK (status IN ('pending', 'accepted', 'declined', 'cancelled')),
+		created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		responded_at TIMESTAMPTZ
+	)`,
+	// 同じ人への承諾待ちの招待は1件だけ
+	`CREATE UNIQUE INDEX IF NOT EXISTS room_invitations_pending_idx
+		ON room_invitations (room_id, invitee_id) WHERE status = 'pending'`,
+	`CREATE INDEX IF NOT EXISTS room_invitations_invitee_idx ON room_invitations (invitee_id, status)`,
+
+	// --- 招待リンク（期限・回数制限つき、無効化できる） ---
+	`CREATE TABLE IF NOT EXISTS room_invite_links (
+		id         SERIAL PRIMARY KEY,
+		room_id    INTEGER NOT NULL REFERENCES chat_rooms(id) ON DELETE CASCADE,
+		token      TEXT NOT NULL UNIQUE,
+		created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		expires_at TIMESTAMPTZ NOT NULL,
+		max_uses   INTEGER CHECK (max_uses > 0),
+		use_count  INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		revoked_at TIMESTAMPTZ
+	)`,
+	`CREATE INDEX IF NOT EXISTS room_invite_links_room_idx ON room_invite_links (room_id)`,
+
+	// --- 1対1ルームの2人（小さいID, 大きいID）。同じ2人のルームは1つだけ ---
+	`ALTER TABLE chat_rooms ADD COLUMN IF NOT EXISTS dm_user_low INTEGER REFERENCES users(id) ON DELETE SET NULL`,
+	`ALTER TABLE chat_rooms ADD COLUMN IF NOT EXISTS dm_user_high INTEGER REFERENCES users(id) ON DELETE SET NULL`,
+	// 既存の1対1ルームに2人を記録する（重複して作られていた組は一番古いルームだけ）
+	`UPDATE chat_rooms cr SET dm_user_low = p.low, dm_user_high = p.high
+	 FROM (
+		SELECT DISTINCT ON (m.low, m.high) m.room_id, m.low, m.high
+		FROM (
+			SELECT room_id, MIN(user_id) AS low, MAX(user_id) AS high
+			FROM room_members GROUP BY room_id HAVING COUNT(DISTINCT user_id) = 2
+		) m
+		JOIN chat_rooms c ON c.id = m.room_id AND NOT c.is_group AND c.dm_user_low IS NULL
+		WHERE NOT EXISTS (SELECT 1 FROM chat_rooms k WHERE k.dm_user_low = m.low AND k.dm_user_high = m.high)
+		ORDER BY m.low, m.high, m.room_id
+	 ) p
+	 WHERE cr.id = p.room_id`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS chat_rooms_dm_pair_idx ON chat_rooms (dm_user_low, dm_user_high)`,
+}
+
+// スキーマ変更を順番に実行（失敗したら起動を止める）
+func migrate() {
+	for _, stmt := range migrations {
+		if _, err := db.Exec(stmt); err != nil {
+			log.Fatalf("Failed to migrate database: %v\n%s", err, stmt)
+		}
+	}
+}
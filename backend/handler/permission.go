@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+)
+
+// ルーム内の役割（room_members.role）
+const (
+	roleOwner    = "owner"     // グループに1人。すべての操作ができる
+	roleAdmin    = "admin"     // メンバー管理・他人のメッセージ削除ができる
+	roleMember   = "member"    // ふつうのメンバー
+	roleReadOnly = "read_only" // 読むだけ（送信・リアクションはできない）
+)
+
+// ルーム内の操作
+type roomAction string
+
+const (
+	actionView          roomAction = "view"           // メッセージを読む・既読にする・自分用に非表示にする
+	actionSend          roomAction = "send"           // 送信・返信・転送先にする・自分のメッセージの編集
+	actionReact         roomAction = "react"          // 絵文字リアクション
+	actionPin           roomAction = "pin"            // ピン留め・解除
+	actionModerate      roomAction = "moderate"       // 他人のメッセージを削除
+	actionManageMembers roomAction = "manage_members" // メンバーの追加・削除・招待
+	actionManageRoom    roomAction = "manage_room"    // グループ名の変更
+	actionManageRoles   roomAction = "manage_roles"   // 役割の変更
+	actionTransferOwner roomAction = "transfer_owner" // オーナーを譲る
+	actionDeleteRoom    roomAction = "delete_room"    // ルームを削除
+)
+
+// 役割ごとに許される操作
+var rolePermissions = map[string]map[roomAction]bool{
+	roleOwner: {
+		actionView: true, actionSend: true, actionReact: true, actionPin: true, actionModerate: true,
+		actionManageMembers: true, actionManageRoom: true, actionManageRoles: true,
+		actionTransferOwner: true, actionDeleteRoom: true,
+	},
+	roleAdmin: {
+		actionView: true, actionSend: true, actionReact: true, actionPin: true, actionModerate: true,
+		actionManageMembers: true, actionManageRoom: true,
+	},
+	roleMember: {
+		actionView: true, actionSend: true, actionReact: true, actionPin: true,
+	},
+	roleReadOnly: {
+		actionView: true,
+	},
+}
+
+// 役割の強さ（メンバー管理で自分より上の人を操作できないようにする）
+var roleRank = map[string]int{roleReadOnly: 0, roleMember: 1, roleAdmin: 2, roleOwner: 3}
+
+func validRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// 役割で操作が許されるか
+func roleAllows(role string, action roomAction) bool {
+	return rolePermissions[role][action]
+}
+
+// ルームでの役割（メンバーでなければ errNotRoomMember）
+func roomRole(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, roomID, userID int) (string, error) {
+	var role string
+	err := q.QueryRow(`SELECT role FROM room_members WHERE room_id = $1 AND user_id = $2`, roomID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", errNotRoomMember
+	}
+	return role, err
+}
+
+// ユーザーがルームで操作をしてよいか（メンバーでなければ false）
+func can(userID int, action roomAction, roomID int) (bool, error) {
+	role, err := roomRole(db, roomID, userID)
+	if err == errNotRoomMember {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return roleAllows(role, action), nil
+}
+
+// 操作が許されていなければ403を返す（続行してよければ true）
+func requirePermission(w http.ResponseWriter, userID int, action roomAction, roomID int) bool {
+	ok, err := can(userID, action, roomID)
+	if err != nil {
+		log.Println("❌ permission check error:", err)
+		http.Error(w, "DB error", http.StatusInternalServerError)
+		return false
+	}
+	if !ok {
+		http.Error(w, "Forbidden: you are not allowed to "+string(action)+" in this room", http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"backend/auth"
+
+	"github.com/lib/pq"
+)
+
+// 各メッセージのピン留め状態を1回のクエリで埋める
+func fillPins(messages []MessageResponse) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(messages))
+	index := make(map[int]int, len(messages))
+	for i, msg := range messages {
+		ids[i] = int64(msg.ID)
+		index[msg.ID] = i
+	}
+
+	rows, err := db.Query(`SELECT message_id, pinned_by, pinned_at FROM pinned_messages WHERE message_id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID int
+		var pinnedBy *int // ピン留めしたユーザーが退会していれば NULL
+		var pinnedAt time.Time
+		if err := rows.Scan(&messageID, &pinnedBy, &pinnedAt); err != nil {
+			return err
+		}
+		msg := &messages[index[messageID]]
+		msg.Pinned = true
+		msg.PinnedBy = pinnedBy
+		msg.PinnedAt = formatTime(&pinnedAt)
+	}
+	return rows.Err()
+}
+
+// POST / DELETE /messages/{id}/pin：ピン留め・解除（読み取り専用以外のメンバー）
+func PinMessageHandler(w http.ResponseWriter, r *http.Request, messageIDStr string) {
+	userID := auth.UserID(r)
+
+	messageID, err := strconv.Atoi(messageIDStr)
+	if err != nil {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	roomID, ok := requireMessageAccess(w, messageID, userID)
+	if !ok || !requirePermission(w, userID, actionPin, roomID) {
+		return
+	}
+
+	event := map[string]interface{}{
+		"room_id":    roomID,
+		"message_id": messageID,
+		"user_id":    userID,
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		// 取り消し済みのメッセージやスレッドの返信はピン留めできない
+		var isDeleted bool
+		var parentID *int
+		if err := db.QueryRow(`SELECT is_deleted, parent_id FROM messages WHERE id = $1`, messageID).Scan(&isDeleted, &parentID); err != nil {
+			http.Error(w, "Failed to pin message", http.StatusInternalServerError)
+			return
+		}
+		if isDeleted || parentID != nil {
+			http.Error(w, "This message cannot be pinned", http.StatusConflict)
+			return
+		}
+
+		var pinnedAt time.Time
+		err := db.QueryRow(`
+			INSERT INTO pinned_messages (message_id, room_id, pinned_by)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (message_id) DO NOTHING
+			RETURNING pinned_at
+		`, messageID, roomID, userID).Scan(&pinnedAt)
+		if err == nil {
+			// 新しくピン留めされたときだけ通知
+			event["type"] = "pin"
+			event["pinned_at"] = pinnedAt.Format(time.RFC3339)
+			BroadcastToRoom(roomID, event)
+		} else if err != sql.ErrNoRows {
+			http.Error(w, "Failed to pin message", http.StatusInternalServerError)
+			return
+		}
+
+	case http.MethodDelete:
+		res, err := db.Exec(`DELETE FROM pinned_messages WHERE message_id = $1`, messageID)
+		if err != nil {
+			http.Error(w, "Failed to unpin message", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			event["type"] = "unpin"
+			BroadcastToRoom(roomID, event)
+		}
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET /pinned_messages?room_id=○：ルームのピン留め一覧（新しくピン留めした順）
+func GetPinnedMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := auth.UserID(r)
+
+	roomID, err := strconv.Atoi(r.URL.Query().Get("room_id"))
+	if err != nil {
+		http.Error(w, "Invalid room_id", http.StatusBadRequest)
+		return
+	}
+	if !requireRoomMember(w, roomID, userID) {
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT `+messageColumns+`
+		FROM pinned_messages p
+		JOIN messages m ON m.id = p.message_id
+		WHERE p.room_id = $1 AND NOT m.is_deleted AND NOT ($2 = ANY(m.hidden_user_ids))
+		ORDER BY p.pinned_at DESC
+	`, roomID, userID)
+	if err != nil {
+		http.Error(w, "Failed to fetch pinned messages", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	messages, err := scanMessages(rows)
+	if err != nil {
+		http.Error(w, "Failed to parse messages", http.StatusInternalServerError)
+		return
+	}
+	if messages == nil {
+		messages = []MessageResponse{}
+	}
+	if err := fillPins(messages); err != nil {
+		http.Error(w, "Failed to fetch pins", http.StatusInternalServerError)
+		return
+	}
+	if err := fillReactions(userID, messages); err != nil {
+		http.Error(w, "Failed to fetch reactions", http.StatusInternalServerError)
+		return
+	}
+	if err := fillQuotes(messages); err != nil {
+		http.Error(w, "Failed to fetch quotes", http.StatusInternalServerError)
+		return
+	}
+	if err := fillAttachments(messages); err != nil {
+		http.Error(w, "Failed to fetch attachments", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(messages)
+}
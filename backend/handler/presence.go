@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"log"
+	"time"
+
+	"backend/hub"
+	"backend/presence"
+)
+
+// オンライン状態の管理（複数タブ・複数ルームの接続をユーザー単位でまとめる）
+var presenceTracker = presence.New()
+
+// WebSocket接続時：最初の接続ならオンラインを通知
+func userConnected(userID int) {
+	if presenceTracker.Connect(userID) {
+		broadcastPresence(userID, presence.Online, touchLastSeen(userID))
+	}
+}
+
+// WebSocket切断時：最後の接続なら入力中表示を消してオフラインを通知し、最終接続時刻を保存
+// （他のタブがまだつながっていれば、そのタブの入力中表示は残す）
+func userDisconnected(userID int) {
+	if presenceTracker.Disconnect(userID) {
+		typing.disconnect(userID)
+		broadcastPresence(userID, presence.Offline, touchLastSeen(userID))
+	}
+}
+
+// presence イベント：クライアントから online / away を切り替える
+func handlePresence(data map[string]interface{}, client *hub.Client) {
+	status, _ := data["status"].(string)
+	if status != presence.Online && status != presence.Away {
+		sendWSError(client, "status must be online or away")
+		return
+	}
+
+	if presenceTracker.SetAway(client.UserID, status == presence.Away) {
+		broadcastPresence(client.UserID, status, touchLastSeen(client.UserID))
+	}
+}
+
+// users.last_seen_at を現在時刻にして返す
+func touchLastSeen(userID int) *string {
+	var lastSeen time.Time
+	err := db.QueryRow(`UPDATE users SET last_seen_at = NOW() WHERE id = $1 RETURNING last_seen_at`, userID).Scan(&lastSeen)
+	if err != nil {
+		log.Println("❌ last_seen_at 更新失敗:", err)
+		return nil
+	}
+	return formatTime(&lastSeen)
+}
+
+// 同じルームにいるユーザーへ状態の変化を通知（ルームが複数重なっても1回だけ）
+func broadcastPresence(userID int, status string, lastSeenAt *string) {
+	otherIDs, err := sharedRoomUserIDs(userID)
+	if err != nil {
+		log.Println("❌ presence room lookup error:", err)
+		return
+	}
+
+	event := map[string]interface{}{
+		"type":         "presence",
+		"user_id":      userID,
+		"status":       status,
+		"last_seen_at": lastSeenAt,
+	}
+	for _, otherID := range otherIDs {
+		chatHub.SendToUser(otherID, event)
+	}
+}
+
+// 同じルームに所属している自分以外のユーザー
+func sharedRoomUserIDs(userID int) ([]int, error) {
+	rows, err := db.Query(`
+		SELECT DISTINCT other.user_id
+		FROM room_members me
+		JOIN room_members other ON other.room_id = me.room_id
+		WHERE me.user_id = $1 AND other.user_id != $1
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// NULLを含む時刻をJSON用の文字列に（NULLなら nil）
+func formatTime(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	s := t.Format(time.RFC3339)
+	return &s
+}
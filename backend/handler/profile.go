@@ -1,14 +1,124 @@
 package handler
 
 import (
-	"fmt"
+	"database/sql"
+	"encoding/json"
 	"io"
+	"log"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
+
+	"backend/auth"
 )
 
+// プロフィール画像の保存先でのキーの接頭辞（URLの /images/ と同じ）
+const profileImagePrefix = "images"
+
+// プロフィール（GET /api/profile/{id}・GET /api/me・プロフィール更新の通知）
+type Profile struct {
+	ID                     int         `json:"id"`
+	Username               string      `json:"username"`
+	Email                  string      `json:"email,omitempty"` // 本人に返すときだけ
+	ProfileImageURL        *string     `json:"profile_image_url"`
+	ProfileImageThumbnails []Thumbnail `json:"profile_image_thumbnails"`
+	ProfileMessage         string      `json:"profile_message"`
+	Status                 string      `json:"status"` // online / away / offline
+	LastSeenAt             *string     `json:"last_seen_at"`
+	CreatedAt              string      `json:"created_at"`
+	UpdatedAt              *string     `json:"updated_at"` // 最後にプロフィールを更新した日時
+}
+
+// プロフィールをDBから読む（メールアドレスは呼び出し側で必要なときだけ残す）
+func loadProfile(userID int) (Profile, error) {
+	var p Profile
+	var imageURL string
+	var thumbnails []byte
+	var lastSeen, updatedAt *time.Time
+	var createdAt time.Time
+	err := db.QueryRow(`
+		SELECT id, username, email, COALESCE(profile_image_url, ''), profile_image_thumbnails,
+		       COALESCE(profile_message, ''), last_seen_at, created_at, updated_at
+		FROM users WHERE id = $1
+	`, userID).Scan(&p.ID, &p.Username, &p.Email, &imageURL, &thumbnails, &p.ProfileMessage, &lastSeen, &createdAt, &updatedAt)
+	if err != nil {
+		return p, err
+	}
+	if imageURL != "" {
+		p.ProfileImageURL = &imageURL
+	}
+	p.ProfileImageThumbnails = decodeThumbnails(thumbnails, "/"+profileImagePrefix+"/")
+	p.Status = presenceTracker.Status(p.ID)
+	p.LastSeenAt = formatTime(lastSeen)
+	p.CreatedAt = createdAt.Format(time.RFC3339)
+	p.UpdatedAt = formatTime(updatedAt)
+	return p, nil
+}
+
+// プロフィールをJSONで返す
+func writeProfile(w http.ResponseWriter, userID int, includeEmail bool) {
+	p, err := loadProfile(userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch profile", http.StatusInternalServerError)
+		return
+	}
+	if !includeEmail {
+		p.Email = ""
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+// GET /api/me：自分のプロフィール（メールアドレス付き）
+func GetMeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeProfile(w, auth.UserID(r), true)
+}
+
+// GET /api/profile/{id}：ユーザーのプロフィール
+func GetProfileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/profile/"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	writeProfile(w, id, id == auth.UserID(r))
+}
+
+// プロフィールの変更を、同じルームのメンバーと本人の他の接続に知らせる
+func broadcastProfile(userID int) {
+	p, err := loadProfile(userID)
+	if err != nil {
+		log.Println("❌ profile lookup error:", err)
+		return
+	}
+	p.Email = ""
+
+	otherIDs, err := sharedRoomUserIDs(userID)
+	if err != nil {
+		log.Println("❌ profile room lookup error:", err)
+		return
+	}
+	event := map[string]interface{}{
+		"type":    "profile",
+		"profile": p,
+	}
+	for _, otherID := range append(otherIDs, userID) {
+		chatHub.SendToUser(otherID, event)
+	}
+}
+
 func UpdateProfileHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -21,75 +131,69 @@ func UpdateProfileHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// ==== user_id を取得 ====
-	userIDStr := r.FormValue("user_id")
-	userID, err := strconv.Atoi(userIDStr)
-	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+	// ==== user_id を取得（トークンのユーザー。フォームの user_id は本人と一致する場合のみ許可） ====
+	userID := auth.UserID(r)
+	if formID := r.FormValue("user_id"); formID != "" && formID != strconv.Itoa(userID) {
+		http.Error(w, "Forbidden: user_id does not match authenticated user", http.StatusForbidden)
 		return
 	}
-	fmt.Println("📦 user_id:", userID)
 
 	// ==== 現在のプロフィール情報を取得 ====
 	var currentImageURL, currentMessage string
-	err = db.QueryRow(`SELECT profile_image_url, profile_message FROM users WHERE id = $1`, userID).Scan(&currentImageURL, &currentMessage)
+	var currentThumbnails []byte
+	err = db.QueryRow(`SELECT COALESCE(profile_image_url, ''), COALESCE(profile_message, ''), profile_image_thumbnails FROM users WHERE id = $1`, userID).
+		Scan(&currentImageURL, &currentMessage, &currentThumbnails)
 	if err != nil {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
 
 	// ==== ファイル処理 ====
-	file, handler, err := r.FormFile("image")
+	file, _, err := r.FormFile("image")
 	imagePath := currentImageURL
+	thumbnails := currentThumbnails
 
 	if err == nil {
 		defer file.Close()
 
-		imageDir := "public/images"
-		if err := os.MkdirAll(imageDir, os.ModePerm); err != nil {
-			http.Error(w, "Failed to create image directory", http.StatusInternalServerError)
+		data, err := io.ReadAll(file)
+		if err != nil {
+			http.Error(w, "Failed to read image file", http.StatusBadRequest)
 			return
 		}
 
-		savePath := filepath.Join(imageDir, handler.Filename)
-		dst, err := os.Create(savePath)
-		if err != nil {
-			http.Error(w, "Failed to create image file", http.StatusInternalServerError)
+		// EXIF（位置情報など）を取り除き、向きを正してからサムネイルと一緒に保存
+		key, _, thumbs, err := storeProcessedImage(nil, profileImagePrefix, data, avatarThumbnailSizes)
+		if status, ok := imageErrorStatus(err); ok {
+			http.Error(w, "Invalid image: "+err.Error(), status)
 			return
 		}
-		defer dst.Close()
-
-		if _, err := io.Copy(dst, file); err != nil {
-			http.Error(w, "Failed to write image file", http.StatusInternalServerError)
+		if err != nil {
+			http.Error(w, "Failed to save image file", http.StatusInternalServerError)
 			return
 		}
 
-		imagePath = "/images/" + handler.Filename
-		fmt.Println("✅ 画像保存成功:", imagePath)
-	} else {
-		fmt.Println("📎 画像未選択または取得失敗:", err)
+		imagePath = "/images/" + key
+		thumbnails = encodeThumbnails(thumbs)
+	} else if err != http.ErrMissingFile {
+		log.Println("❌ profile image read error:", err)
 	}
 
-	// ==== メッセージ取得 ====
+	// ==== メッセージ取得（未入力なら現状のまま） ====
 	message := r.FormValue("message")
 	if message == "" {
 		message = currentMessage
-		fmt.Println("📎 メッセージ未入力。現状のメッセージを保持")
-	} else {
-		fmt.Println("💬 メッセージ受信:", message)
 	}
 
 	// ==== DB 更新 ====
-	query := `UPDATE users SET profile_image_url = $1, profile_message = $2 WHERE id = $3`
-	res, err := db.Exec(query, imagePath, message, userID)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to update profile: %s", err), http.StatusInternalServerError)
+	query := `UPDATE users SET profile_image_url = $1, profile_message = $2, profile_image_thumbnails = $3, updated_at = NOW() WHERE id = $4`
+	if _, err := db.Exec(query, imagePath, message, thumbnails, userID); err != nil {
+		log.Println("❌ profile update error:", err)
+		http.Error(w, "Failed to update profile", http.StatusInternalServerError)
 		return
 	}
 
-	rows, _ := res.RowsAffected()
-	fmt.Printf("✅ DB更新完了: %d 行更新\n", rows)
+	broadcastProfile(userID)
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Profile updated successfully"))
+	writeProfile(w, userID, true)
 }
@@ -0,0 +1,195 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"unicode/utf8"
+
+	"backend/auth"
+
+	"github.com/lib/pq"
+)
+
+// 引用に載せる本文の最大文字数
+const quoteSnippetLength = 100
+
+// 引用できないメッセージ
+var errInvalidQuote = errors.New("quoted message must be in the same room")
+
+// 引用返信の元メッセージ（本文は先頭だけ）
+type QuotedMessage struct {
+	ID         int    `json:"id"`
+	SenderID   int    `json:"sender_id"`
+	SenderName string `json:"sender_name"`
+	Snippet    string `json:"snippet"`    // 取り消し済みなら空
+	IsDeleted  bool   `json:"is_deleted"` // 元メッセージが取り消されたか
+}
+
+// 転送元の送信者
+type ForwardInfo struct {
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+}
+
+// POST /messages/{id}/forward のリクエスト
+type ForwardRequest struct {
+	RoomID int `json:"room_id"` // 転送先ルーム
+}
+
+// 引用元が同じルームのメッセージであること
+func validateQuotedMessage(roomID, messageID int) error {
+	var quotedRoomID int
+	err := db.QueryRow(`SELECT room_id FROM messages WHERE id = $1`, messageID).Scan(&quotedRoomID)
+	if err == sql.ErrNoRows || (err == nil && quotedRoomID != roomID) {
+		return errInvalidQuote
+	}
+	return err
+}
+
+// 本文の先頭 quoteSnippetLength 文字
+func snippet(content string) string {
+	if utf8.RuneCountInString(content) <= quoteSnippetLength {
+		return content
+	}
+	return string([]rune(content)[:quoteSnippetLength]) + "…"
+}
+
+// 引用元と転送元の情報をまとめて埋める（クエリは最大2回）
+func fillQuotes(messages []MessageResponse) error {
+	var quoteIDs, userIDs []int64
+	for _, msg := range messages {
+		if msg.replyToID != nil {
+			quoteIDs = append(quoteIDs, int64(*msg.replyToID))
+		}
+		if msg.forwardedFromUserID != nil {
+			userIDs = append(userIDs, int64(*msg.forwardedFromUserID))
+		}
+	}
+
+	quotes := map[int]*QuotedMessage{}
+	if len(quoteIDs) > 0 {
+		rows, err := db.Query(`
+			SELECT m.id, m.sender_id, u.username, m.content, m.is_deleted
+			FROM messages m
+			JOIN users u ON u.id = m.sender_id
+			WHERE m.id = ANY($1)
+		`, pq.Array(quoteIDs))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var q QuotedMessage
+			var content string
+			if err := rows.Scan(&q.ID, &q.SenderID, &q.SenderName, &content, &q.IsDeleted); err != nil {
+				return err
+			}
+			if !q.IsDeleted {
+				q.Snippet = snippet(content)
+			}
+			quotes[q.ID] = &q
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	users := map[int]*ForwardInfo{}
+	if len(userIDs) > 0 {
+		rows, err := db.Query(`SELECT id, username FROM users WHERE id = ANY($1)`, pq.Array(userIDs))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var f ForwardInfo
+			if err := rows.Scan(&f.UserID, &f.Username); err != nil {
+				return err
+			}
+			users[f.UserID] = &f
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	for i := range messages {
+		msg := &messages[i]
+		if msg.replyToID != nil {
+			msg.ReplyTo = quotes[*msg.replyToID]
+		}
+		if msg.forwardedFromUserID != nil {
+			msg.ForwardedFrom = users[*msg.forwardedFromUserID]
+		}
+	}
+	return nil
+}
+
+// POST /messages/{id}/forward：メッセージを自分が所属する別のルームへ転送する
+func ForwardMessageHandler(w http.ResponseWriter, r *http.Request, messageIDStr string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := auth.UserID(r)
+
+	messageID, err := strconv.Atoi(messageIDStr)
+	if err != nil {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	var req ForwardRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	// 転送元・転送先どちらのルームにも所属していること
+	if _, ok := requireMessageAccess(w, messageID, userID); !ok {
+		return
+	}
+	if !requirePermission(w, userID, actionSend, req.RoomID) {
+		return
+	}
+
+	// 元メッセージ（転送の転送なら最初の送信者を引き継ぐ）
+	var content string
+	var senderID int
+	var originalSenderID *int
+	var isDeleted bool
+	err = db.QueryRow(`SELECT content, sender_id, forwarded_from_user_id, is_deleted FROM messages WHERE id = $1`, messageID).
+		Scan(&content, &senderID, &originalSenderID, &isDeleted)
+	if err != nil {
+		http.Error(w, "Failed to load message", http.StatusInternalServerError)
+		return
+	}
+	if isDeleted {
+		http.Error(w, "Message is deleted", http.StatusConflict)
+		return
+	}
+	if originalSenderID == nil {
+		originalSenderID = &senderID
+	}
+
+	res, err := createMessage(Message{
+		RoomID:                 req.RoomID,
+		SenderID:               userID,
+		Content:                content,
+		ForwardedFromMessageID: &messageID,
+		ForwardedFromUserID:    originalSenderID,
+	})
+	if err != nil {
+		http.Error(w, "Failed to forward message", http.StatusInternalServerError)
+		return
+	}
+
+	broadcastNewMessage(res, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"backend/auth"
+
+	"github.com/lib/pq"
+)
+
+// 絵文字として受け付ける最大文字数（肌色・ZWJ結合の絵文字も収まる長さ）
+const maxEmojiLength = 16
+
+// メッセージに付いたリアクションの集計（絵文字ごと）
+type ReactionSummary struct {
+	Emoji       string `json:"emoji"`
+	Count       int    `json:"count"`
+	ReactedByMe bool   `json:"reacted_by_me"` // リクエストしたユーザーが付けているか
+}
+
+// POST / DELETE /messages/{id}/reactions のリクエスト
+type ReactionRequest struct {
+	Emoji string `json:"emoji"`
+}
+
+// 各メッセージのリアクション集計を1回のクエリで埋める
+func fillReactions(userID int, messages []MessageResponse) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(messages))
+	index := make(map[int]int, len(messages))
+	for i, msg := range messages {
+		ids[i] = int64(msg.ID)
+		index[msg.ID] = i
+		messages[i].Reactions = []ReactionSummary{}
+	}
+
+	rows, err := db.Query(`
+		SELECT message_id, emoji, COUNT(*), BOOL_OR(user_id = $2)
+		FROM message_reactions
+		WHERE message_id = ANY($1)
+		GROUP BY message_id, emoji
+		ORDER BY MIN(created_at)
+	`, pq.Array(ids), userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID int
+		var rs ReactionSummary
+		if err := rows.Scan(&messageID, &rs.Emoji, &rs.Count, &rs.ReactedByMe); err != nil {
+			return err
+		}
+		i := index[messageID]
+		messages[i].Reactions = append(messages[i].Reactions, rs)
+	}
+	return rows.Err()
+}
+
+// 絵文字の入力チェック
+func validEmoji(emoji string) bool {
+	return emoji != "" &&
+		utf8.ValidString(emoji) &&
+		utf8.RuneCountInString(emoji) <= maxEmojiLength &&
+		!strings.ContainsAny(emoji, " \t\r\n")
+}
+
+// /messages/{id}/reactions：POST で追加、DELETE で取り消し
+func MessageReactionsHandler(w http.ResponseWriter, r *http.Request, messageIDStr string) {
+	userID := auth.UserID(r)
+
+	messageID, err := strconv.Atoi(messageIDStr)
+	if err != nil {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	// DELETE は ?emoji= でも指定できる
+	var req ReactionRequest
+	if r.Method == http.MethodDelete && r.URL.Query().Get("emoji") != "" {
+		req.Emoji = r.URL.Query().Get("emoji")
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if !validEmoji(req.Emoji) {
+		http.Error(w, "Invalid emoji", http.StatusBadRequest)
+		return
+	}
+
+	roomID, ok := requireMessageAccess(w, messageID, userID)
+	if !ok || !requirePermission(w, userID, actionReact, roomID) {
+		return
+	}
+
+	var res sql.Result
+	var action string
+	switch r.Method {
+	case http.MethodPost:
+		// 送信取消されたメッセージには付けられない
+		var isDeleted bool
+		if err := db.QueryRow(`SELECT is_deleted FROM messages WHERE id = $1`, messageID).Scan(&isDeleted); err != nil {
+			http.Error(w, "Failed to add reaction", http.StatusInternalServerError)
+			return
+		}
+		if isDeleted {
+			http.Error(w, "Message is deleted", http.StatusConflict)
+			return
+		}
+		action = "add"
+		res, err = db.Exec(`
+			INSERT INTO message_reactions (message_id, user_id, emoji)
+			VALUES ($1, $2, $3) ON CONFLICT DO NOTHING
+		`, messageID, userID, req.Emoji)
+	case http.MethodDelete:
+		action = "remove"
+		res, err = db.Exec(`
+			DELETE FROM message_reactions
+			WHERE message_id = $1 AND user_id = $2 AND emoji = $3
+		`, messageID, userID, req.Emoji)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to update reaction", http.StatusInternalServerError)
+		return
+	}
+
+	// 変化があったときだけ通知（件数は最新の値を数え直す）
+	if n, _ := res.RowsAffected(); n > 0 {
+		var count int
+		db.QueryRow(`SELECT COUNT(*) FROM message_reactions WHERE message_id = $1 AND emoji = $2`, messageID, req.Emoji).Scan(&count)
+
+		BroadcastToRoom(roomID, map[string]interface{}{
+			"type":       "reaction",
+			"action":     action,
+			"room_id":    roomID,
+			"message_id": messageID,
+			"user_id":    userID,
+			"emoji":      req.Emoji,
+			"count":      count,
+		})
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
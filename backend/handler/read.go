@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+
+	"backend/auth"
+)
+
+// POST /mark_read のリクエスト
+type MarkReadRequest struct {
+	RoomID    int `json:"room_id"`
+	MessageID int `json:"message_id"` // このIDまで（含む）を既読にする
+}
+
+// スレッドの返信はルームの既読位置を進めない（それより前の未読まで既読になってしまうため）
+var errThreadReply = errors.New("thread replies are marked read with POST /messages/{id}/replies/read")
+
+// 既読位置をまとめて進める（戻ることはない）。実際に進んだら true
+// messageID はトップレベルのメッセージであること（返信なら errThreadReply）
+func markReadUpTo(roomID, userID, messageID int) (bool, error) {
+	var isReply bool
+	if err := db.QueryRow(`SELECT parent_id IS NOT NULL FROM messages WHERE id = $1`, messageID).Scan(&isReply); err != nil {
+		return false, err
+	}
+	if isReply {
+		return false, errThreadReply
+	}
+
+	res, err := db.Exec(`
+		UPDATE room_members
+		SET last_read_message_id = $3
+		WHERE room_id = $1 AND user_id = $2 AND last_read_message_id < $3
+	`, roomID, userID, messageID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// 既読位置が進んだことを通知（ルームの購読者と、未読バッジを揃えるため本人の全接続へ）
+func broadcastMarkRead(roomID, userID, messageID int) {
+	event := map[string]interface{}{
+		"type":                 "mark_read",
+		"room_id":              roomID,
+		"user_id":              userID,
+		"last_read_message_id": messageID,
+	}
+	chatHub.BroadcastToSubscribers(roomID, event, nil)
+	chatHub.SendToUser(userID, event)
+}
+
+// メッセージごとの read_by をメンバーの既読位置から計算（クエリは1回）
+func fillReadBy(roomID int, messages []MessageResponse) error {
+	rows, err := db.Query(`SELECT user_id, last_read_message_id FROM room_members WHERE room_id = $1`, roomID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	watermarks := map[int]int{}
+	for rows.Next() {
+		var userID, lastRead int
+		if err := rows.Scan(&userID, &lastRead); err != nil {
+			return err
+		}
+		watermarks[userID] = lastRead
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range messages {
+		msg := &messages[i]
+		msg.ReadBy = []int{}
+		for userID, lastRead := range watermarks {
+			// 送信者本人は既読に数えない
+			if userID != msg.SenderID && lastRead >= msg.ID {
+				msg.ReadBy = append(msg.ReadBy, userID)
+			}
+		}
+		sort.Ints(msg.ReadBy)
+	}
+	return nil
+}
+
+// POST /mark_read：ルーム内の指定メッセージまでを既読にする
+func MarkReadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := auth.UserID(r)
+
+	var req MarkReadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// メッセージが指定ルームのもので、自分がメンバーであること
+	roomID, ok := requireMessageAccess(w, req.MessageID, userID)
+	if !ok {
+		return
+	}
+	if roomID != req.RoomID {
+		http.Error(w, "Message does not belong to this room", http.StatusBadRequest)
+		return
+	}
+
+	advanced, err := markReadUpTo(roomID, userID, req.MessageID)
+	if err == errThreadReply {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to mark as read", http.StatusInternalServerError)
+		return
+	}
+	if advanced {
+		broadcastMarkRead(roomID, userID, req.MessageID)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
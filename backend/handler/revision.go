@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"backend/auth"
+)
+
+var (
+	errNotMessageSender = errors.New("not the sender of this message")
+	errMessageDeleted   = errors.New("message is deleted")
+)
+
+// 編集履歴の1版
+type MessageRevision struct {
+	Revision  int    `json:"revision"`   // 0 が最初の投稿、以降は編集ごとに +1
+	Content   string `json:"content"`    // その版の本文
+	EditedBy  *int   `json:"edited_by"`  // その版を書いたユーザー（退会していれば null）
+	CreatedAt string `json:"created_at"` // その版になった日時
+	Current   bool   `json:"current"`    // 現在の本文かどうか
+}
+
+// 本文を書き換え、直前の本文を履歴に残す。新しい版番号と編集日時を返す
+func editMessage(messageID, userID int, content string) (int, time.Time, error) {
+	var revision int
+	var editedAt time.Time
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, editedAt, err
+	}
+	defer tx.Rollback()
+
+	// 同時に編集されても版番号が飛ばないよう行をロックする
+	var (
+		senderID   int
+		oldContent string
+		writtenAt  time.Time
+		isDeleted  bool
+		isSystem   bool
+	)
+	err = tx.QueryRow(`
+		SELECT sender_id, content, COALESCE(edited_at, created_at), is_deleted, revision, system_event IS NOT NULL
+		FROM messages WHERE id = $1 FOR UPDATE
+	`, messageID).Scan(&senderID, &oldContent, &writtenAt, &isDeleted, &revision, &isSystem)
+	if err != nil {
+		return 0, editedAt, err
+	}
+	// システムメッセージは操作した本人でも書き換えられない
+	if senderID != userID || isSystem {
+		return 0, editedAt, errNotMessageSender
+	}
+	if isDeleted {
+		return 0, editedAt, errMessageDeleted
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO message_revisions (message_id, revision, content, edited_by, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (message_id, revision) DO NOTHING
+	`, messageID, revision, oldContent, senderID, writtenAt); err != nil {
+		return 0, editedAt, err
+	}
+
+	err = tx.QueryRow(`
+		UPDATE messages SET content = $1, edited_at = NOW(), revision = revision + 1
+		WHERE id = $2
+		RETURNING revision, edited_at
+	`, content, messageID).Scan(&revision, &editedAt)
+	if err != nil {
+		return 0, editedAt, err
+	}
+	return revision, editedAt, tx.Commit()
+}
+
+// GET /messages/{id}/revisions：編集履歴（古い順、最後が現在の本文）
+func MessageRevisionsHandler(w http.ResponseWriter, r *http.Request, messageIDStr string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := auth.UserID(r)
+
+	messageID, err := strconv.Atoi(messageIDStr)
+	if err != nil {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+	if _, ok := requireMessageAccess(w, messageID, userID); !ok {
+		return
+	}
+
+	var current MessageRevision
+	var senderID int
+	var writtenAt time.Time
+	var isDeleted bool
+	err = db.QueryRow(`
+		SELECT revision, content, sender_id, COALESCE(edited_at, created_at), is_deleted
+		FROM messages WHERE id = $1
+	`, messageID).Scan(&current.Revision, &current.Content, &senderID, &writtenAt, &isDeleted)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch revisions", http.StatusInternalServerError)
+		return
+	}
+	// 取り消したメッセージの履歴は見せない
+	if isDeleted {
+		http.Error(w, "Message is deleted", http.StatusConflict)
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT revision, content, edited_by, created_at
+		FROM message_revisions
+		WHERE message_id = $1
+		ORDER BY revision
+	`, messageID)
+	if err != nil {
+		http.Error(w, "Failed to fetch revisions", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	revisions := []MessageRevision{}
+	for rows.Next() {
+		var rev MessageRevision
+		var createdAt time.Time
+		if err := rows.Scan(&rev.Revision, &rev.Content, &rev.EditedBy, &createdAt); err != nil {
+			http.Error(w, "Failed to parse revisions", http.StatusInternalServerError)
+			return
+		}
+		rev.CreatedAt = createdAt.Format(time.RFC3339)
+		revisions = append(revisions, rev)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "Failed to parse revisions", http.StatusInternalServerError)
+		return
+	}
+
+	current.EditedBy = &senderID
+	current.CreatedAt = writtenAt.Format(time.RFC3339)
+	current.Current = true
+	revisions = append(revisions, current)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message_id": messageID,
+		"revisions":  revisions,
+	})
+}
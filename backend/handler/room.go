@@ -6,6 +6,8 @@ import (
 	"net/http"
 	"strconv"
 	"time"
+
+	"backend/auth"
 )
 
 type RoomDisplay struct {
@@ -18,38 +20,32 @@ type RoomDisplay struct {
 }
 
 type RoomMember struct {
-	UserID   int    `json:"user_id"`
-	Username string `json:"username"`
+	UserID     int     `json:"user_id"`
+	Username   string  `json:"username"`
+	Status     string  `json:"status"`       // online / away / offline
+	LastSeenAt *string `json:"last_seen_at"` // 最終接続時刻
+	Role       string  `json:"role"`         // owner / admin / member / read_only
 }
 
 type CreateGroupRequest struct {
 	GroupName string `json:"group_name"` // ← ここが正しい
-	MemberIDs []int  `json:"member_ids"`
+	MemberIDs []int  `json:"member_ids"` // 招待する人（承諾するとメンバーになる）
 }
 
 // ルーム一覧取得
 func GetMyRoomsHandler(w http.ResponseWriter, r *http.Request) {
-	userIDStr, err := GetUserIDFromToken(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	userID, err := strconv.Atoi(userIDStr)
-	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
-		return
-	}
+	userID := auth.UserID(r)
 
 	query := `
 WITH unread_counts AS (
+  -- 既読位置より新しい、自分以外のメッセージ数
   SELECT
     m.room_id,
     COUNT(*) AS unread_count
-  FROM messages m
-  LEFT JOIN message_reads mr
-    ON m.id = mr.message_id AND mr.user_id = $1
-  WHERE mr.message_id IS NULL AND m.sender_id != $1
+  FROM room_members me
+  JOIN messages m
+    ON m.room_id = me.room_id AND m.id > me.last_read_message_id
+  WHERE me.user_id = $1 AND m.sender_id != $1 AND m.parent_id IS NULL
   GROUP BY m.room_id
 )
 
@@ -122,14 +118,25 @@ ORDER BY last_message_time DESC;
 
 // ルームのメンバー一覧
 func GetRoomMembersHandler(w http.ResponseWriter, r *http.Request) {
-	roomID := r.URL.Query().Get("room_id")
-	if roomID == "" {
+	userID := auth.UserID(r)
+
+	roomIDStr := r.URL.Query().Get("room_id")
+	if roomIDStr == "" {
 		http.Error(w, "Missing room_id", http.StatusBadRequest)
 		return
 	}
+	roomID, err := strconv.Atoi(roomIDStr)
+	if err != nil {
+		http.Error(w, "Invalid room_id", http.StatusBadRequest)
+		return
+	}
+
+	if !requireRoomMember(w, roomID, userID) {
+		return
+	}
 
 	query := `
-		SELECT u.id, u.username
+		SELECT u.id, u.username, u.last_seen_at, rm.role
 		FROM users u
 		JOIN room_members rm ON u.id = rm.user_id
 		WHERE rm.room_id = $1;
@@ -144,10 +151,13 @@ func GetRoomMembersHandler(w http.ResponseWriter, r *http.Request) {
 	var members []RoomMember
 	for rows.Next() {
 		var member RoomMember
-		if err := rows.Scan(&member.UserID, &member.Username); err != nil {
+		var lastSeen *time.Time
+		if err := rows.Scan(&member.UserID, &member.Username, &lastSeen, &member.Role); err != nil {
 			http.Error(w, "Scan error", http.StatusInternalServerError)
 			return
 		}
+		member.LastSeenAt = formatTime(lastSeen)
+		member.Status = presenceTracker.Status(member.UserID)
 		members = append(members, member)
 	}
 
@@ -157,12 +167,7 @@ func GetRoomMembersHandler(w http.ResponseWriter, r *http.Request) {
 
 // グループ作成
 func CreateGroupHandler(w http.ResponseWriter, r *http.Request) {
-	userIDStr, err := GetUserIDFromToken(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-	userID, _ := strconv.Atoi(userIDStr)
+	userID := auth.UserID(r)
 
 	var req CreateGroupRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -170,31 +175,53 @@ func CreateGroupHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to create room", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
 	query := `
   INSERT INTO chat_rooms (room_name, is_group, created_by, created_at)
   VALUES ($1, true, $2, NOW()) RETURNING id
 `
 
 	var roomID int
-	if err := db.QueryRow(query, req.GroupName, userID).Scan(&roomID); err != nil {
+	if err := tx.QueryRow(query, req.GroupName, userID).Scan(&roomID); err != nil {
 		http.Error(w, "Failed to create room", http.StatusInternalServerError)
 		return
 	}
 
-	memberIDs := append(req.MemberIDs, userID)
-	for _, memberID := range memberIDs {
-		_, err := db.Exec("INSERT INTO room_members (room_id, user_id) VALUES ($1, $2)", roomID, memberID)
-		if err != nil {
-			http.Error(w, "Failed to add members", http.StatusInternalServerError)
-			return
-		}
+	// 作成者はオーナー。他の人は招待を承諾するとメンバーになる
+	if _, err := tx.Exec("INSERT INTO room_members (room_id, user_id, role) VALUES ($1, $2, $3)", roomID, userID, roleOwner); err != nil {
+		http.Error(w, "Failed to add members", http.StatusInternalServerError)
+		return
+	}
+	invitations, err := inviteUsers(tx, roomID, userID, req.MemberIDs)
+	if err != nil {
+		http.Error(w, "Failed to invite members", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to create room", http.StatusInternalServerError)
+		return
+	}
+
+	// 接続中ならすぐイベントが届くように
+	chatHub.AddMember(roomID, userID)
+	invited := []int{}
+	for _, inv := range invitations {
+		invited = append(invited, inv.InviteeID)
+		notifyInvitation(inv)
 	}
 
 	// ✅ 成功レスポンスに display_name を含める（group名）
 	res := map[string]interface{}{
-		"message":      "グループ作成完了",
-		"room_id":      roomID,
-		"display_name": req.GroupName,
+		"message":          "グループ作成完了",
+		"room_id":          roomID,
+		"display_name":     req.GroupName,
+		"invited_user_ids": invited,
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(res)
@@ -0,0 +1,212 @@
+package handler
+
+import (
+	"encoding/json"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"backend/auth"
+
+	"github.com/lib/pq"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 50
+	maxSearchTerms     = 5   // 検索語の最大数
+	maxSearchLength    = 100 // 検索文字列の最大文字数
+	searchSnippetWidth = 40  // ヒット位置の前後に残す文字数
+)
+
+// 検索結果1件
+type SearchHit struct {
+	MessageID  int    `json:"message_id"`
+	RoomID     int    `json:"room_id"`
+	RoomName   string `json:"room_name"`
+	IsGroup    bool   `json:"is_group"`
+	ParentID   *int   `json:"parent_id,omitempty"` // スレッドの返信なら親メッセージID
+	SenderID   int    `json:"sender_id"`
+	SenderName string `json:"sender_name"`
+	Snippet    string `json:"snippet"` // HTMLエスケープ済み。ヒット箇所は <mark> で囲む
+	CreatedAt  string `json:"created_at"`
+	Cursor     int    `json:"cursor"`   // 開くときの around に渡すメッセージID
+	JumpURL    string `json:"jump_url"` // その位置を開くAPI（返信ならスレッド、それ以外はルームのタイムライン）
+}
+
+// 検索結果の位置を開くAPIのパス（スレッドの返信はタイムラインに出ないのでスレッド側を開く）
+func searchJumpURL(hit SearchHit) string {
+	if hit.ParentID != nil {
+		return "/messages/" + strconv.Itoa(*hit.ParentID) + "/replies?around=" + strconv.Itoa(hit.Cursor)
+	}
+	return "/messages?room_id=" + strconv.Itoa(hit.RoomID) + "&around=" + strconv.Itoa(hit.Cursor)
+}
+
+// GET /search のレスポンス
+type SearchResponse struct {
+	Results    []SearchHit `json:"results"`
+	NextBefore *int        `json:"next_before"` // 続きを取るときの before（なければ null）
+}
+
+// LIKE のワイルドカードをエスケープして部分一致パターンにする
+func likePattern(term string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + r.Replace(term) + "%"
+}
+
+// ヒット箇所の前後を切り出し、検索語を <mark> で囲む
+func highlightSnippet(content string, terms []string) string {
+	runes := []rune(content)
+
+	// 大文字小文字を無視して、各位置から始まる検索語の長さを記録
+	marks := make([]int, len(runes))
+	first := -1
+	for i := range runes {
+		for _, term := range terms {
+			n := utf8.RuneCountInString(term)
+			if i+n <= len(runes) && n > marks[i] && strings.EqualFold(string(runes[i:i+n]), term) {
+				marks[i] = n
+				if first < 0 || i < first {
+					first = i
+				}
+			}
+		}
+	}
+
+	start, end := 0, len(runes)
+	if first > searchSnippetWidth {
+		start = first - searchSnippetWidth
+	}
+	if first >= 0 && first+searchSnippetWidth*2 < end {
+		end = first + searchSnippetWidth*2
+	} else if first < 0 && end > searchSnippetWidth*2 {
+		end = searchSnippetWidth * 2
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	for i := start; i < end; {
+		if n := marks[i]; n > 0 {
+			stop := i + n
+			if stop > end {
+				stop = end
+			}
+			b.WriteString("<mark>" + html.EscapeString(string(runes[i:stop])) + "</mark>")
+			i = stop
+			continue
+		}
+		b.WriteString(html.EscapeString(string(runes[i])))
+		i++
+	}
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// GET /search?q=○：自分が所属するルームのメッセージを検索（新しい順）
+// room_id で絞り込み、before（メッセージID）と limit でページング
+func SearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := auth.UserID(r)
+	q := r.URL.Query()
+
+	query := strings.TrimSpace(q.Get("q"))
+	if query == "" || utf8.RuneCountInString(query) > maxSearchLength {
+		http.Error(w, "Invalid q", http.StatusBadRequest)
+		return
+	}
+	terms := strings.Fields(query)
+	if len(terms) > maxSearchTerms {
+		terms = terms[:maxSearchTerms]
+	}
+	patterns := make([]string, len(terms))
+	for i, term := range terms {
+		patterns[i] = likePattern(term)
+	}
+
+	roomID, before, limit := 0, 0, defaultSearchLimit
+	var err error
+	if v := q.Get("room_id"); v != "" {
+		if roomID, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "Invalid room_id", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := q.Get("before"); v != "" {
+		if before, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "Invalid before", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		if limit > maxSearchLimit {
+			limit = maxSearchLimit
+		}
+	}
+
+	// 単語単位の全文検索（英語など）と、部分一致（日本語など分かち書きしない言語。pg_trgm のインデックスが効く）
+	rows, err := db.Query(`
+		SELECT m.id, m.room_id, cr.room_name, cr.is_group, m.parent_id, m.sender_id, u.username, m.content, m.created_at
+		FROM messages m
+		JOIN room_members rm ON rm.room_id = m.room_id AND rm.user_id = $1
+		JOIN chat_rooms cr ON cr.id = m.room_id
+		JOIN users u ON u.id = m.sender_id
+		WHERE NOT m.is_deleted
+		  AND NOT ($1 = ANY(m.hidden_user_ids))
+		  AND (to_tsvector('simple', m.content) @@ plainto_tsquery('simple', $2)
+		       OR m.content ILIKE ALL($3))
+		  AND ($4 = 0 OR m.room_id = $4)
+		  AND ($5 = 0 OR m.id < $5)
+		ORDER BY m.id DESC
+		LIMIT $6
+	`, userID, query, pq.Array(patterns), roomID, before, limit+1)
+	if err != nil {
+		http.Error(w, "Failed to search messages", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	res := SearchResponse{Results: []SearchHit{}}
+	for rows.Next() {
+		var hit SearchHit
+		var content string
+		var createdAt time.Time
+		if err := rows.Scan(&hit.MessageID, &hit.RoomID, &hit.RoomName, &hit.IsGroup, &hit.ParentID,
+			&hit.SenderID, &hit.SenderName, &content, &createdAt); err != nil {
+			http.Error(w, "Failed to parse results", http.StatusInternalServerError)
+			return
+		}
+		hit.Snippet = highlightSnippet(content, terms)
+		hit.CreatedAt = createdAt.Format(time.RFC3339)
+		hit.Cursor = hit.MessageID
+		hit.JumpURL = searchJumpURL(hit)
+		res.Results = append(res.Results, hit)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "Failed to parse results", http.StatusInternalServerError)
+		return
+	}
+
+	// 1件多く取れていれば続きがある
+	if len(res.Results) > limit {
+		res.Results = res.Results[:limit]
+		next := res.Results[limit-1].MessageID
+		res.NextBefore = &next
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
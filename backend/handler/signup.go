@@ -7,6 +7,9 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+
+	"backend/auth"
 
 	_ "github.com/lib/pq"        // PostgreSQL ドライバ（接続のために必要）
 	"golang.org/x/crypto/bcrypt" // パスワードを安全に保存するためのハッシュ化ライブラリ
@@ -49,6 +52,9 @@ func InitDB() {
 	}
 
 	fmt.Println("Successfully connected to the database")
+
+	// 足りないカラムやテーブルを追加
+	migrate()
 }
 
 // サインアップAPIハンドラー（POST /signup）
@@ -93,18 +99,24 @@ func SignupHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-// ユーザー削除API（GET /delete?id=○）
+// ユーザー削除API（DELETE /delete、本人のみ。?id=○ を付ける場合は自分のIDであること）
 func DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id") // クエリパラメータから id を取得
+	// GET で消えるとリンクや <img> から削除されてしまう
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := auth.UserID(r)
 
-	if id == "" {
-		http.Error(w, "Missing user ID", http.StatusBadRequest)
+	// 他人のIDを指定された場合は拒否
+	if id := r.URL.Query().Get("id"); id != "" && id != strconv.Itoa(userID) {
+		http.Error(w, "Forbidden: you can only delete your own account", http.StatusForbidden)
 		return
 	}
 
-	// 指定したIDのユーザーを削除
+	// ログイン中のユーザーを削除
 	query := `DELETE FROM users WHERE id = $1`
-	res, err := db.Exec(query, id)
+	res, err := db.Exec(query, userID)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error deleting user: %s", err), http.StatusInternalServerError)
 		return
@@ -5,7 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"strconv"
+
+	"backend/auth"
 )
 
 // リクエストの構造体：チャット相手のユーザーIDを受け取る
@@ -15,24 +16,63 @@ type StartChatRequest struct {
 
 // レスポンスの構造体：作成または取得したルームIDを返す
 type StartChatResponse struct {
-	RoomID int `json:"room_id"`
+	RoomID  int  `json:"room_id"`
+	Created bool `json:"created"` // 新しく作ったルームなら true
 }
 
-// StartChatHandler：1対1チャットを開始するAPI
-func StartChatHandler(w http.ResponseWriter, r *http.Request) {
-	// 🔐 JWTトークンから自分のユーザーIDを取得（ログインチェック）
-	userIDStr, err := GetUserIDFromToken(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
+// 2人の1対1ルームを返す（なければ作る）
+// ルームは (小さいID, 大きいID) の組で一意なので、同時に呼ばれても1つしか作られない
+func getOrCreateDMRoom(userA, userB int) (roomID int, created bool, err error) {
+	low, high := userA, userB
+	if low > high {
+		low, high = high, low
 	}
 
-	// 🔢 文字列の userID を整数に変換（DBで使うため）
-	user1ID, err := strconv.Atoi(userIDStr)
-	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
-		return
+	// 作成と同時に消された場合などに備えて、もう一度だけやり直す
+	for attempt := 0; attempt < 2; attempt++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return 0, false, err
+		}
+
+		// 🏠 先に同じ組を作っている処理があれば、そのコミットを待ってから何もしない
+		err = tx.QueryRow(`
+			INSERT INTO chat_rooms (room_name, is_group, dm_user_low, dm_user_high)
+			VALUES ($1, false, $2, $3)
+			ON CONFLICT (dm_user_low, dm_user_high) DO NOTHING
+			RETURNING id
+		`, fmt.Sprintf("Chat %d-%d", low, high), low, high).Scan(&roomID)
+		if err == nil {
+			// 👥 自分と相手を登録
+			if _, err := tx.Exec(
+				`INSERT INTO room_members (room_id, user_id) VALUES ($1, $2), ($1, $3)`,
+				roomID, low, high,
+			); err != nil {
+				tx.Rollback()
+				return 0, false, err
+			}
+			return roomID, true, tx.Commit()
+		}
+		tx.Rollback()
+		if err != sql.ErrNoRows {
+			return 0, false, err
+		}
+
+		// 🔍 既にある（または今コミットされた）ルーム
+		err = db.QueryRow(`
+			SELECT id FROM chat_rooms WHERE dm_user_low = $1 AND dm_user_high = $2
+		`, low, high).Scan(&roomID)
+		if err != sql.ErrNoRows {
+			return roomID, false, err
+		}
 	}
+	return 0, false, fmt.Errorf("dm room for users %d and %d disappeared while creating it", low, high)
+}
+
+// StartChatHandler：1対1チャットを開始するAPI（既にあればそのルームを返す）
+func StartChatHandler(w http.ResponseWriter, r *http.Request) {
+	// 🔐 認証ミドルウェアが検証済みの自分のユーザーIDを取得
+	userID := auth.UserID(r)
 
 	// 📦 JSONのリクエストボディをパースして相手のIDを取得
 	var req StartChatRequest
@@ -40,63 +80,38 @@ func StartChatHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid input", http.StatusBadRequest)
 		return
 	}
-	user2ID := req.ReceiverID
-
-	// 🔍 すでにこの2人のチャットルームが存在するかを確認（順不同に対応）
-	query := `
-		SELECT cr.id
-		FROM chat_rooms cr
-		JOIN room_members rm1 ON cr.id = rm1.room_id
-		JOIN room_members rm2 ON cr.id = rm2.room_id
-		WHERE cr.is_group = false
-		AND rm1.user_id IN ($1, $2)
-		AND rm2.user_id IN ($1, $2)
-		GROUP BY cr.id
-		HAVING COUNT(DISTINCT rm1.user_id) = 2
-		LIMIT 1;
-	`
-
-	var roomID int
-	err = db.QueryRow(query, user1ID, user2ID).Scan(&roomID)
-
-	if err == sql.ErrNoRows {
-		// ✅ 同じ2人のルームがなければ、新しく作成する
-		tx, err := db.Begin() // トランザクション開始
-		if err != nil {
-			http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
-			return
-		}
-
-		// 🏠 chat_rooms テーブルに新しいルームを追加（is_group = false は1対1の意味）
-		err = tx.QueryRow(
-			`INSERT INTO chat_rooms (room_name, is_group) VALUES ($1, false) RETURNING id`,
-			fmt.Sprintf("Chat %d-%d", user1ID, user2ID), // 仮のルーム名
-		).Scan(&roomID)
-		if err != nil {
-			tx.Rollback()
-			http.Error(w, "Failed to create room", http.StatusInternalServerError)
-			return
-		}
-
-		// 👥 room_members テーブルに自分と相手を登録（ルームに参加）
-		_, err = tx.Exec(
-			`INSERT INTO room_members (room_id, user_id) VALUES ($1, $2), ($1, $3)`,
-			roomID, user1ID, user2ID,
-		)
-		if err != nil {
-			tx.Rollback()
-			http.Error(w, "Failed to add members", http.StatusInternalServerError)
-			return
-		}
+	if req.ReceiverID <= 0 || req.ReceiverID == userID {
+		http.Error(w, "Invalid receiver_id", http.StatusBadRequest)
+		return
+	}
 
-		tx.Commit() // トランザクション完了
-	} else if err != nil {
-		// その他のDBエラー
+	// 相手が存在するか
+	var exists bool
+	if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, req.ReceiverID).Scan(&exists); err != nil {
 		http.Error(w, "DB error", http.StatusInternalServerError)
 		return
 	}
+	if !exists {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	roomID, created, err := getOrCreateDMRoom(userID, req.ReceiverID)
+	if err != nil {
+		http.Error(w, "Failed to create room", http.StatusInternalServerError)
+		return
+	}
+
+	if created {
+		// 接続中の2人にこのルームのイベントが届くように
+		chatHub.AddMember(roomID, userID)
+		chatHub.AddMember(roomID, req.ReceiverID)
+	}
 
 	// 🎉 最後にルームIDをJSON形式でクライアントに返す
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(StartChatResponse{RoomID: roomID})
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(StartChatResponse{RoomID: roomID, Created: created})
 }
@@ -0,0 +1,226 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"backend/auth"
+	"backend/hub"
+
+	"github.com/lib/pq"
+)
+
+// 返信先として使えない親メッセージ
+var errInvalidThreadParent = errors.New("parent message must be a top-level message in the same room")
+
+// POST /messages/{id}/replies/read のリクエスト
+type ThreadReadRequest struct {
+	ReplyID int `json:"reply_id"` // この返信まで（含む）を既読にする
+}
+
+// 親メッセージが同じルームにあり、それ自体は返信でなく、取り消されていないこと
+func validateThreadParent(roomID, parentID int) error {
+	var parentRoomID int
+	var grandParentID *int
+	var isDeleted bool
+	err := db.QueryRow(`SELECT room_id, parent_id, is_deleted FROM messages WHERE id = $1`, parentID).
+		Scan(&parentRoomID, &grandParentID, &isDeleted)
+	if err == sql.ErrNoRows {
+		return errInvalidThreadParent
+	}
+	if err != nil {
+		return err
+	}
+	if parentRoomID != roomID || grandParentID != nil || isDeleted {
+		return errInvalidThreadParent
+	}
+	return nil
+}
+
+// スレッドの既読位置を進める（戻ることはない）。実際に進んだら true
+func markThreadReadUpTo(parentID, userID, replyID int) (bool, error) {
+	res, err := db.Exec(`
+		INSERT INTO thread_reads (parent_id, user_id, last_read_reply_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (parent_id, user_id) DO UPDATE
+		SET last_read_reply_id = EXCLUDED.last_read_reply_id
+		WHERE thread_reads.last_read_reply_id < EXCLUDED.last_read_reply_id
+	`, parentID, userID, replyID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// 親メッセージごとの返信数・最終返信日時・未読返信数を1回のクエリで埋める
+func fillThreadSummaries(userID int, messages []MessageResponse) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(messages))
+	index := make(map[int]int, len(messages))
+	for i, msg := range messages {
+		ids[i] = int64(msg.ID)
+		index[msg.ID] = i
+	}
+
+	rows, err := db.Query(`
+		SELECT m.parent_id,
+		       COUNT(*),
+		       MAX(m.created_at),
+		       COUNT(*) FILTER (WHERE m.id > COALESCE(tr.last_read_reply_id, 0) AND m.sender_id != $2)
+		FROM messages m
+		LEFT JOIN thread_reads tr ON tr.parent_id = m.parent_id AND tr.user_id = $2
+		WHERE m.parent_id = ANY($1) AND NOT ($2 = ANY(m.hidden_user_ids))
+		GROUP BY m.parent_id
+	`, pq.Array(ids), userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var parentID, count, unread int
+		var lastReplyAt time.Time
+		if err := rows.Scan(&parentID, &count, &lastReplyAt, &unread); err != nil {
+			return err
+		}
+		msg := &messages[index[parentID]]
+		msg.ReplyCount = count
+		msg.LastReplyAt = formatTime(&lastReplyAt)
+		msg.ThreadUnreadCount = unread
+	}
+	return rows.Err()
+}
+
+// 新しいメッセージを配信（返信なら親の返信数と一緒に thread_reply として送る）
+func broadcastNewMessage(msg MessageResponse, exclude *hub.Client) {
+	if msg.ParentID == nil {
+		chatHub.BroadcastToRoom(msg.RoomID, MessageEvent{Type: "message", MessageResponse: msg}, exclude)
+		return
+	}
+
+	var replyCount int
+	var lastReplyAt time.Time
+	err := db.QueryRow(`SELECT COUNT(*), MAX(created_at) FROM messages WHERE parent_id = $1`, *msg.ParentID).
+		Scan(&replyCount, &lastReplyAt)
+	if err != nil {
+		log.Println("❌ スレッド集計失敗:", err)
+	}
+
+	chatHub.BroadcastToRoom(msg.RoomID, map[string]interface{}{
+		"type":          "thread_reply",
+		"room_id":       msg.RoomID,
+		"parent_id":     *msg.ParentID,
+		"reply_count":   replyCount,
+		"last_reply_at": formatTime(&lastReplyAt),
+		"message":       msg,
+	}, exclude)
+}
+
+// スレッドの親メッセージを確認し、ルームIDを返す（エラー時はレスポンスを書いて ok=false）
+func requireThreadParent(w http.ResponseWriter, parentIDStr string, userID int) (parentID, roomID int, ok bool) {
+	parentID, err := strconv.Atoi(parentIDStr)
+	if err != nil {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	roomID, ok = requireMessageAccess(w, parentID, userID)
+	return parentID, roomID, ok
+}
+
+// GET /messages/{id}/replies：スレッドの返信一覧（before / after / around / limit でページング）
+func ThreadRepliesHandler(w http.ResponseWriter, r *http.Request, parentIDStr string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := auth.UserID(r)
+
+	parentID, roomID, ok := requireThreadParent(w, parentIDStr, userID)
+	if !ok {
+		return
+	}
+
+	params, err := parseMessagePageParams(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := fetchMessagesPage(roomID, parentID, userID, params)
+	if err != nil {
+		http.Error(w, "Failed to fetch replies", http.StatusInternalServerError)
+		return
+	}
+	if err := fillReadBy(roomID, page.Messages); err != nil {
+		http.Error(w, "Failed to fetch read_by", http.StatusInternalServerError)
+		return
+	}
+	if err := fillReactions(userID, page.Messages); err != nil {
+		http.Error(w, "Failed to fetch reactions", http.StatusInternalServerError)
+		return
+	}
+	if err := fillQuotes(page.Messages); err != nil {
+		http.Error(w, "Failed to fetch quotes", http.StatusInternalServerError)
+		return
+	}
+	if err := fillAttachments(page.Messages); err != nil {
+		http.Error(w, "Failed to fetch attachments", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// POST /messages/{id}/replies/read：スレッドの返信を指定IDまで既読にする
+func MarkThreadReadHandler(w http.ResponseWriter, r *http.Request, parentIDStr string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := auth.UserID(r)
+
+	parentID, _, ok := requireThreadParent(w, parentIDStr, userID)
+	if !ok {
+		return
+	}
+
+	var req ThreadReadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// 返信がこのスレッドのものか
+	var replyParentID *int
+	err := db.QueryRow(`SELECT parent_id FROM messages WHERE id = $1`, req.ReplyID).Scan(&replyParentID)
+	if err != nil || replyParentID == nil || *replyParentID != parentID {
+		http.Error(w, "Reply does not belong to this thread", http.StatusBadRequest)
+		return
+	}
+
+	advanced, err := markThreadReadUpTo(parentID, userID, req.ReplyID)
+	if err != nil {
+		http.Error(w, "Failed to mark as read", http.StatusInternalServerError)
+		return
+	}
+	if advanced {
+		// 本人の他のタブのスレッド未読数を揃える
+		chatHub.SendToUser(userID, map[string]interface{}{
+			"type":               "thread_read",
+			"parent_id":          parentID,
+			"last_read_reply_id": req.ReplyID,
+		})
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
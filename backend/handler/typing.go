@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"sync"
+	"time"
+
+	"backend/hub"
+)
+
+const (
+	typingTTL         = 5 * time.Second // 更新がなければ入力中表示を消すまでの時間
+	typingMinInterval = time.Second     // typing_start を受け付ける最短間隔（連打対策）
+	typingMaxPerUser  = 5               // 1ユーザーが typingMinInterval の間に送れる typing_start の数（ルームを問わず）
+)
+
+// ルーム内の誰が入力中か
+type typingKey struct {
+	roomID int
+	userID int
+}
+
+type typingState struct {
+	expiry *time.Timer // typingTTL 経過で自動的に typing_stop を流す
+}
+
+// 入力中インジケーターの管理
+type typingTracker struct {
+	mu        sync.Mutex
+	states    map[typingKey]*typingState
+	lastStart map[typingKey]time.Time // 最後に受け付けた typing_start（stop を挟んだ連打も抑える）
+	bursts    map[int]*typingBurst    // ユーザーごとの直近の typing_start の数（メンバー確認の前に見る）
+}
+
+type typingBurst struct {
+	since time.Time
+	count int
+}
+
+var typing = &typingTracker{
+	states:    make(map[typingKey]*typingState),
+	lastStart: make(map[typingKey]time.Time),
+	bursts:    make(map[int]*typingBurst),
+}
+
+// typing_start イベント：連打を先に間引き、通ったものだけメンバー確認（DB）をする
+func handleTypingStart(data map[string]interface{}, client *hub.Client) {
+	if !typing.allow(client.UserID) {
+		return
+	}
+	if roomID, ok := eventRoomID(data, client); ok {
+		typing.start(client, roomID)
+	}
+}
+
+// ユーザーごとの typing_start の数を数え、多すぎれば false（ルームIDを変えた連打も抑える）
+func (t *typingTracker) allow(userID int) bool {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b, ok := t.bursts[userID]
+	if !ok || now.Sub(b.since) >= typingMinInterval {
+		t.bursts[userID] = &typingBurst{since: now, count: 1}
+		return true
+	}
+	b.count++
+	return b.count <= typingMaxPerUser
+}
+
+// typing_start：初回なら他の購読者に通知し、期限を延長する
+func (t *typingTracker) start(client *hub.Client, roomID int) {
+	key := typingKey{roomID: roomID, userID: client.UserID}
+	now := time.Now()
+
+	t.mu.Lock()
+	if now.Sub(t.lastStart[key]) < typingMinInterval {
+		// 短時間の連打は無視
+		t.mu.Unlock()
+		return
+	}
+	t.lastStart[key] = now
+
+	st, exists := t.states[key]
+	if exists {
+		st.expiry.Reset(typingTTL)
+		t.mu.Unlock()
+		return
+	}
+	st = &typingState{}
+	st.expiry = time.AfterFunc(typingTTL, func() { t.expire(key, st) })
+	t.states[key] = st
+	t.mu.Unlock()
+
+	chatHub.BroadcastToSubscribers(key.roomID, typingEvent("typing_start", key), client)
+}
+
+// typing_stop：入力中だった場合だけ通知する
+func (t *typingTracker) stop(roomID, userID int) {
+	key := typingKey{roomID: roomID, userID: userID}
+
+	t.mu.Lock()
+	st, exists := t.states[key]
+	if exists {
+		st.expiry.Stop()
+		delete(t.states, key)
+	}
+	t.mu.Unlock()
+
+	if exists {
+		chatHub.BroadcastToSubscribers(roomID, typingEvent("typing_stop", key), nil)
+	}
+}
+
+// 最後の接続が切れたとき：そのユーザーの入力中表示をすべて消し、連打対策の記録も捨てる
+func (t *typingTracker) disconnect(userID int) {
+	var roomIDs []int
+	t.mu.Lock()
+	for key := range t.states {
+		if key.userID == userID {
+			roomIDs = append(roomIDs, key.roomID)
+		}
+	}
+	for key := range t.lastStart {
+		if key.userID == userID {
+			delete(t.lastStart, key)
+		}
+	}
+	delete(t.bursts, userID)
+	t.mu.Unlock()
+
+	for _, roomID := range roomIDs {
+		t.stop(roomID, userID)
+	}
+}
+
+// 期限切れ（その間に別の状態に置き換わっていれば何もしない）
+func (t *typingTracker) expire(key typingKey, st *typingState) {
+	t.mu.Lock()
+	if t.states[key] != st {
+		t.mu.Unlock()
+		return
+	}
+	delete(t.states, key)
+	t.mu.Unlock()
+
+	chatHub.BroadcastToSubscribers(key.roomID, typingEvent("typing_stop", key), nil)
+}
+
+func typingEvent(eventType string, key typingKey) map[string]interface{} {
+	return map[string]interface{}{
+		"type":    eventType,
+		"room_id": key.roomID,
+		"user_id": key.userID,
+	}
+}
@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 送信取消できる期間（環境変数 UNSEND_WINDOW、例 "15m" や "24h"。"0" なら無期限）
+var unsendWindow = func() time.Duration {
+	if s := os.Getenv("UNSEND_WINDOW"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err == nil && d >= 0 {
+			return d
+		}
+		log.Println("⚠️ invalid UNSEND_WINDOW, using default:", s)
+	}
+	return 24 * time.Hour
+}()
+
+var errUnsendWindowExpired = errors.New("unsend window has expired")
+
+// 送信取消：本文と付随データを消して、行だけ墓標として残す（並び順と既読位置を崩さないため）
+// moderator（管理者）は他人のメッセージも期間に関係なく消せる
+// 消した添付ファイルの保存名と、ピン留めが外れたかを返す
+func unsendMessage(messageID, userID int, moderator bool) (storageKeys []string, pinned bool, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	var senderID int
+	var content string
+	var createdAt time.Time
+	var isDeleted, isSystem bool
+	err = tx.QueryRow(`SELECT sender_id, content, created_at, is_deleted, system_event IS NOT NULL FROM messages WHERE id = $1 FOR UPDATE`, messageID).
+		Scan(&senderID, &content, &createdAt, &isDeleted, &isSystem)
+	if err != nil {
+		return nil, false, err
+	}
+	own := senderID == userID && !isSystem
+	if !own && !moderator {
+		return nil, false, errNotMessageSender
+	}
+	if isDeleted {
+		return nil, false, errMessageDeleted
+	}
+	if own && !moderator && unsendWindow > 0 && time.Since(createdAt) > unsendWindow {
+		return nil, false, errUnsendWindowExpired
+	}
+
+	if _, err := tx.Exec(`UPDATE messages SET content = '', is_deleted = TRUE WHERE id = $1`, messageID); err != nil {
+		return nil, false, err
+	}
+
+	// 添付は行を消し、ファイルはコミット後に使われていなければ消す
+	rows, err := tx.Query(`DELETE FROM attachments WHERE message_id = $1 RETURNING storage_key`, messageID)
+	if err != nil {
+		return nil, false, err
+	}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return nil, false, err
+		}
+		storageKeys = append(storageKeys, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+	// 以前の形式（本文に /uploads/ のURLだけを書いたメッセージ）
+	if strings.HasPrefix(content, "/uploads/") {
+		storageKeys = append(storageKeys, filepath.Base(content))
+	}
+
+	// 編集履歴やリアクションなど、本文に紐づくものも消す
+	for _, stmt := range []string{
+		`DELETE FROM message_revisions WHERE message_id = $1`,
+		`DELETE FROM message_reactions WHERE message_id = $1`,
+		`DELETE FROM mentions WHERE message_id = $1`,
+	} {
+		if _, err := tx.Exec(stmt, messageID); err != nil {
+			return nil, false, err
+		}
+	}
+	res, err := tx.Exec(`DELETE FROM pinned_messages WHERE message_id = $1`, messageID)
+	if err != nil {
+		return nil, false, err
+	}
+	n, _ := res.RowsAffected()
+
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+	return storageKeys, n > 0, nil
+}
+
+// 添付ファイルを消す（同じ中身を他の添付や転送先がまだ使っていれば残す）
+// 確認から削除まで保存名をロックし、その間に同じ中身がアップロードされて参照が増えないようにする
+func removeUnusedUpload(storageKey string) {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("❌ attachment lookup failed:", err)
+		return
+	}
+	defer tx.Rollback()
+	if err := lockStorageKey(tx, uploadPrefix, storageKey); err != nil {
+		log.Println("❌ attachment lookup failed:", err)
+		return
+	}
+
+	var inUse bool
+	err = tx.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM attachments WHERE storage_key = $1)
+		    OR EXISTS (SELECT 1 FROM messages WHERE content = $2 AND NOT is_deleted)
+	`, storageKey, attachmentURL(storageKey)).Scan(&inUse)
+	if err != nil {
+		log.Println("❌ attachment lookup failed:", err)
+		return
+	}
+	if inUse {
+		return
+	}
+	removeThumbnails(uploadPrefix, storageKey, attachmentThumbnailSizes)
+	if err := files.Delete(uploadPrefix + "/" + storageKey); err != nil {
+		log.Println("❌ failed to remove attachment:", err)
+	}
+}
@@ -1,60 +1,115 @@
 package handler
 
 import (
-	"fmt"
+	"encoding/json"
 	"io"
 	"net/http"
-	"os"
-	"path/filepath"
+
+	"backend/auth"
+	"backend/imageproc"
 )
 
-// POST /upload
+// POST /upload：ファイルをアップロードして添付として登録する
+// 返した id をメッセージ送信時の attachment_ids に入れるとメッセージに紐づく
 func UploadImageHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	userID := auth.UserID(r)
 
-	// multipart/form-data をパース
-	err := r.ParseMultipartForm(10 << 20) // 10MB上限
-	if err != nil {
+	// multipart/form-data をパース（大きいファイルは一時ファイルに書かれる）
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize+(1<<20))
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
 		http.Error(w, "Failed to parse form", http.StatusBadRequest)
 		return
 	}
 
-	// フォームから画像ファイルを取得
-	file, handler, err := r.FormFile("image")
+	// フォームからファイルを取得（"image" は以前のクライアント向け）
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		file, header, err = r.FormFile("image")
+	}
 	if err != nil {
-		http.Error(w, "Image file not found", http.StatusBadRequest)
+		http.Error(w, "File not found", http.StatusBadRequest)
 		return
 	}
 	defer file.Close()
 
-	// 保存先ディレクトリ
-	imageDir := "public/uploads"
-	if err := os.MkdirAll(imageDir, os.ModePerm); err != nil {
-		http.Error(w, "Failed to create directory", http.StatusInternalServerError)
+	// 拡張子やクライアントの Content-Type は信用せず、中身から種類を判定
+	mimeType, err := sniffMimeType(file)
+	if err != nil {
+		http.Error(w, "Failed to read file", http.StatusBadRequest)
+		return
+	}
+	kind, ok := attachmentTypes[mimeType]
+	if !ok {
+		http.Error(w, "Unsupported file type: "+mimeType, http.StatusUnsupportedMediaType)
+		return
+	}
+	if header.Size > kind.maxSize {
+		http.Error(w, "File is too large", http.StatusRequestEntityTooLarge)
 		return
 	}
 
-	// 保存ファイル名（同名対策に時間やUUIDを付けるとより安全）
-	savePath := filepath.Join(imageDir, handler.Filename)
-	dst, err := os.Create(savePath)
+	// 保存から登録までを1つのトランザクションにして、同じ中身の削除と入れ違わないようにする
+	tx, err := db.Begin()
 	if err != nil {
-		http.Error(w, "Failed to save file", http.StatusInternalServerError)
+		http.Error(w, "Failed to save attachment", http.StatusInternalServerError)
 		return
 	}
-	defer dst.Close()
+	defer tx.Rollback()
+
+	a := Attachment{MimeType: mimeType, Filename: sanitizeFilename(header.Filename), Thumbnails: []Thumbnail{}}
+	var key string
+	var thumbs []storedThumbnail
+
+	if processedImageTypes[mimeType] {
+		// 画像は EXIF などを取り除き、向きを正してからサムネイルと一緒に保存
+		data, err := io.ReadAll(file)
+		if err != nil {
+			http.Error(w, "Failed to read file", http.StatusBadRequest)
+			return
+		}
+		var orig imageproc.Image
+		key, orig, thumbs, err = storeProcessedImage(tx, uploadPrefix, data, attachmentThumbnailSizes)
+		if status, ok := imageErrorStatus(err); ok {
+			http.Error(w, "Invalid image: "+err.Error(), status)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to process image", http.StatusInternalServerError)
+			return
+		}
+		a.Size = int64(len(orig.Data))
+		a.Width, a.Height = &orig.Width, &orig.Height
+		a.Thumbnails = thumbnailURLs(thumbs, "/uploads/")
+	} else {
+		var size int64
+		key, size, err = storeUpload(tx, uploadPrefix, file, kind.ext)
+		if err != nil {
+			http.Error(w, "Failed to save file", http.StatusInternalServerError)
+			return
+		}
+		a.Size = size
+	}
+	a.URL = attachmentURL(key)
 
-	// ファイル保存
-	if _, err := io.Copy(dst, file); err != nil {
-		http.Error(w, "Failed to write file", http.StatusInternalServerError)
+	err = tx.QueryRow(`
+		INSERT INTO attachments (uploader_id, storage_key, filename, mime_type, size, width, height, thumbnails)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id
+	`, userID, key, a.Filename, a.MimeType, a.Size, a.Width, a.Height, encodeThumbnails(thumbs)).Scan(&a.ID)
+	if err != nil {
+		http.Error(w, "Failed to save attachment", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to save attachment", http.StatusInternalServerError)
 		return
 	}
 
-	// URLパスとして返却
-	imageURL := "/uploads/" + handler.Filename
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, `{"url": "%s"}`, imageURL)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(a)
 }
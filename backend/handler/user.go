@@ -3,30 +3,25 @@ package handler
 import (
 	"encoding/json"
 	"net/http"
-	"strconv"
+	"time"
+
+	"backend/auth"
 )
 
 // 最小限のユーザー情報を表す構造体
 type UserSimple struct {
-	ID       int    `json:"id"`
-	Username string `json:"username"`
+	ID         int     `json:"id"`
+	Username   string  `json:"username"`
+	Status     string  `json:"status"`       // online / away / offline
+	LastSeenAt *string `json:"last_seen_at"` // 最終接続時刻（一度も接続していなければ null）
 }
 
 func GetUsersHandler(w http.ResponseWriter, r *http.Request) {
 	// 認証チェック（JWT）
-	userIDStr, err := GetUserIDFromToken(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-	userID, err := strconv.Atoi(userIDStr)
-	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
-		return
-	}
+	userID := auth.UserID(r)
 
 	// 自分以外のユーザーを取得
-	rows, err := db.Query(`SELECT id, username FROM users WHERE id != $1 ORDER BY username ASC`, userID)
+	rows, err := db.Query(`SELECT id, username, last_seen_at FROM users WHERE id != $1 ORDER BY username ASC`, userID)
 	if err != nil {
 		http.Error(w, "Failed to fetch users", http.StatusInternalServerError)
 		return
@@ -36,10 +31,13 @@ func GetUsersHandler(w http.ResponseWriter, r *http.Request) {
 	var users []UserSimple
 	for rows.Next() {
 		var user UserSimple
-		if err := rows.Scan(&user.ID, &user.Username); err != nil {
+		var lastSeen *time.Time
+		if err := rows.Scan(&user.ID, &user.Username, &lastSeen); err != nil {
 			http.Error(w, "Failed to scan user", http.StatusInternalServerError)
 			return
 		}
+		user.LastSeenAt = formatTime(lastSeen)
+		user.Status = presenceTracker.Status(user.ID)
 		users = append(users, user)
 	}
 
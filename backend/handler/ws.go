@@ -1,24 +1,22 @@
 package handler
 
 import (
-	"encoding/json"
 	"log"
 	"net/http"
-	"sync"
 
 	"strconv"
+	"strings"
 
 	"time"
 
 	"fmt"
 
-	"github.com/golang-jwt/jwt/v5"
+	"backend/auth"
+	"backend/hub"
+
 	"github.com/gorilla/websocket"
 )
 
-// JWTの秘密鍵（本番では環境変数で管理すべき）
-var jwtSecret = []byte("your-secret-key") // フロントと一致させること！
-
 // WebSocketアップグレーダー
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
@@ -27,177 +25,298 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-// 接続管理マップ（ルームIDごと）
-var roomConnections = make(map[string][]*websocket.Conn)
-var mu sync.Mutex
-
-func WebSocketHandler(w http.ResponseWriter, r *http.Request) {
-	roomID := r.URL.Query().Get("room_id")
-	tokenStr := r.URL.Query().Get("token")
+// 接続管理（ユーザーごとの接続とルームの配信先は Hub が持つ）
+var chatHub = hub.New()
 
-	if roomID == "" || tokenStr == "" {
-		http.Error(w, "Missing room_id or token", http.StatusBadRequest)
-		return
-	}
+// Hubのイベントループを起動する関数（アプリ起動時に一度だけ呼ばれる）
+func InitHub() {
+	go chatHub.Run()
+}
 
-	// JWTトークンの検証
-	claims := jwt.RegisteredClaims{}
-	_, err := jwt.ParseWithClaims(tokenStr, &claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
-	if err != nil {
-		http.Error(w, "Invalid token: "+err.Error(), http.StatusUnauthorized)
-		return
+// GET /ws?token=○：ユーザーごとに1本の接続で、所属する全ルームのイベントを受け取る
+// ?room_id=○ を付けるとそのルームを最初から subscribe する（旧クライアント互換）
+func WebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	// 接続のユーザーは認証ミドルウェアが ?token= から確定させたもの
+	userID := auth.UserID(r)
+
+	// 旧クライアント：最初に subscribe するルーム
+	joinRoomID := 0
+	joinAllowed := true
+	if roomID := r.URL.Query().Get("room_id"); roomID != "" {
+		var err error
+		joinRoomID, err = strconv.Atoi(roomID)
+		if err != nil {
+			http.Error(w, "Invalid room_id", http.StatusBadRequest)
+			return
+		}
+		joinAllowed, err = isRoomMember(joinRoomID, userID)
+		if err != nil {
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
 	}
 
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("WebSocket upgrade error:", err)
-		http.Error(w, "WebSocket upgrade failed", http.StatusInternalServerError)
 		return
 	}
-	defer conn.Close()
-
-	log.Printf("✅ WebSocket connected: room_id=%s, user_id=%s\n", roomID, claims.Subject)
 
-	// 接続をマップに登録
-	mu.Lock()
-	roomConnections[roomID] = append(roomConnections[roomID], conn)
-	mu.Unlock()
+	// メンバーでなければ専用のクローズコードで切断（ブラウザはHTTPステータスを読めないため）
+	if !joinAllowed {
+		log.Printf("🚫 WebSocket rejected: user_id=%d is not a member of room_id=%d\n", userID, joinRoomID)
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(closeNotRoomMember, "forbidden: not a member of this room"),
+			time.Now().Add(time.Second))
+		conn.Close()
+		return
+	}
 
-	// 切断時に除去
-	defer func() {
-		mu.Lock()
-		conns := roomConnections[roomID]
-		for i, c := range conns {
-			if c == conn {
-				roomConnections[roomID] = append(conns[:i], conns[i+1:]...)
-				break
-			}
-		}
-		mu.Unlock()
-	}()
-
-	// メッセージ読み込みループ
-	for {
-		var raw map[string]interface{}
-		if err := conn.ReadJSON(&raw); err != nil {
-			log.Println("ReadJSON error:", err)
-			break
+	// クライアントをHubに登録（書き込みは専用ゴルーチンで行う）
+	// 所属ルームは登録してから読む（読み込み中に参加・退出したルームも Hub が反映する）
+	client := hub.NewClient(chatHub, conn, userID)
+	err = chatHub.Register(client, func() ([]int, error) {
+		roomIDs, err := userRoomIDs(userID)
+		if err == nil {
+			log.Printf("✅ WebSocket connected: user_id=%d, rooms=%v\n", userID, roomIDs)
 		}
+		return roomIDs, err
+	})
+	if err != nil {
+		log.Println("❌ WebSocket register error:", err)
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "failed to load rooms"),
+			time.Now().Add(time.Second))
+		conn.Close()
+		return
+	}
+	if joinRoomID != 0 {
+		chatHub.Subscribe(client, joinRoomID)
+	}
+	go client.WritePump()
+	userConnected(userID)
 
+	// メッセージ読み込みループ（切断時にHubから除去される）
+	client.ReadPump(func(raw map[string]interface{}) {
 		eventType, ok := raw["type"].(string)
 		if !ok {
 			log.Println("Invalid event format (no type)")
-			continue
+			return
 		}
 
 		switch eventType {
+		case "subscribe":
+			handleSubscribe(raw, client)
+		case "unsubscribe":
+			handleUnsubscribe(raw, client)
 		case "message":
-			handleNewMessage(raw, conn, roomID)
-		case "message_read":
-			handleMessageRead(raw, roomID)
+			handleNewMessage(raw, client)
+		case "mark_read", "message_read": // message_read は旧クライアント用
+			handleMarkRead(raw, client)
+		case "typing_start":
+			handleTypingStart(raw, client)
+		case "typing_stop":
+			if roomID, ok := eventRoomID(raw, client); ok {
+				typing.stop(roomID, client.UserID)
+			}
+		case "presence":
+			handlePresence(raw, client)
 		default:
 			log.Println("Unknown event type:", eventType)
 		}
+	})
+
+	// 最後の接続だったら入力中表示を消し、オフラインを通知
+	userDisconnected(userID)
+}
+
+// フレームの room_id を取り出し、接続ユーザーがそのメンバーか確認する
+func eventRoomID(data map[string]interface{}, client *hub.Client) (int, bool) {
+	roomIDFloat, ok := data["room_id"].(float64)
+	if !ok {
+		sendWSError(client, "room_id is required")
+		return 0, false
+	}
+	roomID := int(roomIDFloat)
+
+	member, err := isRoomMember(roomID, client.UserID)
+	if err != nil {
+		log.Println("❌ membership check error:", err)
+		sendWSError(client, "failed to check membership")
+		return 0, false
+	}
+	if !member {
+		sendWSError(client, errNotRoomMember.Error())
+		return 0, false
 	}
+	return roomID, true
 }
 
-// 新規メッセージ処理（保存はせず、ブロードキャストのみ）
-func handleNewMessage(data map[string]interface{}, conn *websocket.Conn, roomID string) {
-	log.Println("💬 handleNewMessage called")
+// subscribe：ルームの詳細イベント（入力中・既読）の購読を始める
+func handleSubscribe(data map[string]interface{}, client *hub.Client) {
+	roomID, ok := eventRoomID(data, client)
+	if !ok {
+		return
+	}
+	chatHub.Subscribe(client, roomID)
+	client.Send(map[string]interface{}{"type": "subscribed", "room_id": roomID})
+}
+
+// unsubscribe：購読をやめる（ルームの通常イベントは引き続き届く）
+func handleUnsubscribe(data map[string]interface{}, client *hub.Client) {
+	roomIDFloat, ok := data["room_id"].(float64)
+	if !ok {
+		sendWSError(client, "room_id is required")
+		return
+	}
+	chatHub.Unsubscribe(client, int(roomIDFloat))
+	client.Send(map[string]interface{}{"type": "unsubscribed", "room_id": int(roomIDFloat)})
+}
 
-	data["type"] = "message"
+// クライアントにエラーイベントを返す
+func sendWSError(client *hub.Client, message string) {
+	client.Send(map[string]interface{}{
+		"type":  "error",
+		"error": message,
+	})
+}
 
-	// ログ出力（デバッグ用）
-	if content, ok := data["content"].(string); ok {
-		log.Println("📩 メッセージ内容:", content)
+// ペイロードが接続ユーザーと異なるIDを名乗っていないか確認（省略されていればOK）
+func claimsSameUser(data map[string]interface{}, key string, client *hub.Client) bool {
+	v, exists := data[key]
+	if !exists || v == nil {
+		return true
 	}
+	id, ok := v.(float64)
+	return ok && int(id) == client.UserID
+}
 
-	var msg MessageResponse
-	b, _ := json.Marshal(data)
-	json.Unmarshal(b, &msg)
+// フレームの任意のID項目（なければ nil）
+func optionalID(data map[string]interface{}, key string) *int {
+	v, ok := data[key].(float64)
+	if !ok {
+		return nil
+	}
+	id := int(v)
+	return &id
+}
 
-	// 🆕 sender_id を正しくセット
-	if senderIDFloat, ok := data["sender_id"].(float64); ok {
-		msg.SenderID = int(senderIDFloat)
-		log.Println("👤 sender_id is", msg.SenderID)
-	} else {
-		log.Println("❌ sender_id missing in data")
+// 数値配列のフィールドを取り出す（数値以外の要素は無視）
+func idList(data map[string]interface{}, key string) []int {
+	values, _ := data[key].([]interface{})
+	var ids []int
+	for _, v := range values {
+		if id, ok := v.(float64); ok {
+			ids = append(ids, int(id))
+		}
 	}
+	return ids
+}
 
-	log.Println("📛 checking mentions in:", msg.Content)
-	mentions := extractMentions(msg.Content)
-	log.Println("📛 extracted mentions:", mentions)
+// 新規メッセージ処理（DBに保存し、送信者にACK・他メンバーに保存済みメッセージを配信）
+func handleNewMessage(data map[string]interface{}, client *hub.Client) {
+	log.Println("💬 handleNewMessage called")
 
-	// メンションごとの通知処理
-	for _, username := range mentions {
-		var mentionedUserID int
-		err := db.QueryRow("SELECT id FROM users WHERE username = $1", username).Scan(&mentionedUserID)
-		if err == nil {
-			log.Println("📣 found user_id:", mentionedUserID, "for username:", username)
-			log.Println("👥 comparing:", mentionedUserID, "vs", msg.SenderID)
+	content, _ := data["content"].(string)
+	attachmentIDs := idList(data, "attachment_ids")
+	if strings.TrimSpace(content) == "" && len(attachmentIDs) == 0 {
+		sendWSError(client, "content is required")
+		return
+	}
 
-			if mentionedUserID != msg.SenderID {
-				go BroadcastMentionNotification(msg.RoomID, mentionedUserID, msg.SenderID, msg.Content)
-			}
-		}
+	// 送信者は接続に紐づいたユーザー
+	if !claimsSameUser(data, "sender_id", client) {
+		log.Println("🚫 sender_id mismatch from user:", client.UserID)
+		sendWSError(client, "sender_id does not match authenticated user")
+		return
 	}
-	// ...以下省略（既存のbroadcast処理など）
 
-	mu.Lock()
-	defer mu.Unlock()
+	roomID, ok := eventRoomID(data, client)
+	if !ok {
+		return
+	}
+	// 読み取り専用のメンバーは送信できない
+	allowed, err := can(client.UserID, actionSend, roomID)
+	if err != nil {
+		log.Println("❌ permission check error:", err)
+		sendWSError(client, "failed to check permission")
+		return
+	}
+	if !allowed {
+		sendWSError(client, "you are not allowed to send messages in this room")
+		return
+	}
 
-	for _, c := range roomConnections[roomID] {
-		if c != conn {
-			if err := c.WriteJSON(data); err != nil {
-				log.Println("WriteJSON error:", err)
-			}
-		}
+	msg, err := createMessage(Message{
+		RoomID:           roomID,
+		SenderID:         client.UserID,
+		Content:          content,
+		ParentID:         optionalID(data, "parent_id"),
+		ReplyToMessageID: optionalID(data, "reply_to_message_id"),
+		AttachmentIDs:    attachmentIDs,
+	})
+	if err == errInvalidThreadParent || err == errInvalidQuote || err == errInvalidAttachment {
+		sendWSError(client, err.Error())
+		return
+	}
+	if err != nil {
+		log.Println("❌ メッセージ保存失敗:", err)
+		sendWSError(client, "failed to save message")
+		return
 	}
+
+	// 送信者へACK（client_id はクライアントが仮IDとして付けたものをそのまま返す）
+	client.Send(map[string]interface{}{
+		"type":       "message_ack",
+		"client_id":  data["client_id"],
+		"id":         msg.ID,
+		"created_at": msg.CreatedAt,
+		"message":    msg,
+	})
+
+	// 送信者以外に配信（送信者の他のタブには届く）
+	broadcastNewMessage(msg, client)
 }
 
-// 既読通知処理
-func handleMessageRead(data map[string]interface{}, roomID string) {
-	messageIDFloat, ok1 := data["message_id"].(float64)
-	userIDFloat, ok2 := data["user_id"].(float64)
-	if !ok1 || !ok2 {
-		log.Println("Invalid message_read payload")
+// 既読通知処理（message_id までをまとめて既読にする）
+func handleMarkRead(data map[string]interface{}, client *hub.Client) {
+	messageIDFloat, ok := data["message_id"].(float64)
+	if !ok {
+		log.Println("Invalid mark_read payload")
+		sendWSError(client, "message_id is required")
 		return
 	}
 	messageID := int(messageIDFloat)
-	userID := int(userIDFloat)
 
-	// DBに挿入（重複なら無視）
-	_, err := db.Exec(`
-		INSERT INTO message_reads (message_id, user_id) 
-		VALUES ($1, $2) ON CONFLICT DO NOTHING
-	`, messageID, userID)
-	if err != nil {
-		log.Println("Error inserting message_read:", err)
+	// 既読にするのは接続に紐づいたユーザー本人
+	if !claimsSameUser(data, "user_id", client) {
+		log.Println("🚫 user_id mismatch from user:", client.UserID)
+		sendWSError(client, "user_id does not match authenticated user")
 		return
 	}
+	userID := client.UserID
 
-	roomInt, err := strconv.Atoi(roomID)
+	// 自分が所属するルームのメッセージだけ既読にできる
+	roomID, err := messageRoomForMember(messageID, userID)
 	if err != nil {
-		log.Println("Invalid roomID:", roomID)
+		log.Println("🚫 mark_read rejected:", messageID, err)
+		sendWSError(client, "message not found in this room")
 		return
 	}
 
-	// 全クライアントに通知
-	msg := map[string]interface{}{
-		"type":       "message_read",
-		"message_id": messageID,
-		"user_id":    userID,
-		"room_id":    roomInt,
+	advanced, err := markReadUpTo(roomID, userID, messageID)
+	if err == errThreadReply {
+		sendWSError(client, err.Error())
+		return
+	}
+	if err != nil {
+		log.Println("Error updating read watermark:", err)
+		return
 	}
 
-	mu.Lock()
-	defer mu.Unlock()
-	for _, c := range roomConnections[roomID] {
-		if err := c.WriteJSON(msg); err != nil {
-			log.Println("WriteJSON error (message_read):", err)
-		}
+	// 既読位置が進んだときだけ通知
+	if advanced {
+		broadcastMarkRead(roomID, userID, messageID)
 	}
 }
 
@@ -212,27 +331,11 @@ func BroadcastMentionNotification(roomID int, mentionedUserID int, senderID int,
 		"timestamp": time.Now().Format(time.RFC3339),
 	}
 
-	roomIDStr := strconv.Itoa(roomID)
-
-	mu.Lock()
-	defer mu.Unlock()
-	for _, conn := range roomConnections[roomIDStr] {
-		if err := conn.WriteJSON(msg); err != nil {
-			log.Println("WriteJSON error (mention):", err)
-		}
-	}
+	// メンションされた本人だけに届ける（ルームのメンバーであることは呼び出し側で確認済み）
+	chatHub.SendToUser(mentionedUserID, msg)
 }
 
 func BroadcastToRoom(roomID int, data interface{}) {
-	roomStr := strconv.Itoa(roomID)
-	log.Println("📡 Broadcasting to room:", roomStr)
-	mu.Lock()
-	defer mu.Unlock()
-	for _, conn := range roomConnections[roomStr] {
-		log.Println("📨 sending to one conn")
-		if err := conn.WriteJSON(data); err != nil {
-			log.Println("❌ WebSocket送信失敗:", err)
-		}
-	}
-
+	log.Println("📡 Broadcasting to room:", roomID)
+	chatHub.BroadcastToRoom(roomID, data, nil)
 }
@@ -0,0 +1,96 @@
+package hub
+
+import (
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	writeWait      = 10 * time.Second    // 1回の書き込みに許す時間
+	pongWait       = 60 * time.Second    // pong を待つ時間
+	pingPeriod     = (pongWait * 9) / 10 // ping 送信間隔（pongWait より短く）
+	maxMessageSize = 64 * 1024           // 受信メッセージの最大サイズ
+	sendBufferSize = 256                 // 送信キューの長さ
+)
+
+// Client：1本のWebSocket接続を表す（1ユーザーが複数持てる）
+type Client struct {
+	hub    *Hub
+	conn   *websocket.Conn
+	UserID int          // 認証済みユーザーID（JWTから取得。クライアントの申告は信用しない）
+	send   chan []byte  // 送信待ちメッセージ（書き込みは writePump だけが行う）
+	subs   map[int]bool // 詳細イベントを購読中のルーム（Hub の Run ゴルーチンだけが触る）
+}
+
+// 新しいクライアントを作成
+func NewClient(h *Hub, conn *websocket.Conn, userID int) *Client {
+	return &Client{
+		hub:    h,
+		conn:   conn,
+		UserID: userID,
+		send:   make(chan []byte, sendBufferSize),
+		subs:   make(map[int]bool),
+	}
+}
+
+// 送信キューの中身を接続に書き出す（クライアントごとに1ゴルーチン）
+func (c *Client) WritePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case data, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				// Hub が送信キューを閉じた
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				log.Println("WriteMessage error:", err)
+				return
+			}
+
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// 受信ループ：届いたJSONを handle に渡す。切断したら Hub から外して戻る
+func (c *Client) ReadPump(handle func(raw map[string]interface{})) {
+	defer func() {
+		c.hub.Unregister(c)
+		c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+
+	for {
+		var raw map[string]interface{}
+		if err := c.conn.ReadJSON(&raw); err != nil {
+			log.Println("ReadJSON error:", err)
+			return
+		}
+		handle(raw)
+	}
+}
+
+// このクライアントだけに送信
+func (c *Client) Send(v interface{}) {
+	c.hub.SendTo(c, v)
+}
@@ -0,0 +1,276 @@
+package hub
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// Hub：ユーザーごとのWebSocket接続と、ルームへの配信先を管理する
+//   - 接続はユーザー単位（1ユーザーが複数タブ・端末で複数本持てる）
+//   - ルームのメンバーには、そのルームのイベントが自動で届く
+//   - subscribe したルームだけ、入力中・既読などの詳細イベントも届く
+//
+// マップはすべて Run ゴルーチンだけが触るのでロック不要
+type Hub struct {
+	users       map[int]map[*Client]bool // ユーザーID → 接続
+	userRooms   map[int]map[int]bool     // 接続中ユーザーID → 所属ルームID
+	rooms       map[int]map[int]bool     // ルームID → 接続中のメンバーのユーザーID
+	subscribers map[int]map[*Client]bool // ルームID → 詳細イベントを購読している接続
+
+	loading    map[int]map[*registration]bool // ユーザーID → 所属ルームを読み込み中の登録
+	unregister chan *Client
+	ops        chan func() // 上記マップを読み書きする処理（Run の中で実行される）
+}
+
+// 所属ルームを読み込み中の登録
+// 読み込みは Run の外で行うので、その間に RemoveMember されたルームを覚えておき、読み込み結果から除く
+type registration struct {
+	client  *Client
+	removed map[int]bool
+}
+
+// 新しいHubを作成（Run を別ゴルーチンで起動すること）
+func New() *Hub {
+	return &Hub{
+		users:       make(map[int]map[*Client]bool),
+		userRooms:   make(map[int]map[int]bool),
+		rooms:       make(map[int]map[int]bool),
+		subscribers: make(map[int]map[*Client]bool),
+		loading:     make(map[int]map[*registration]bool),
+		unregister:  make(chan *Client),
+		ops:         make(chan func(), 256),
+	}
+}
+
+// イベントループ：登録・解除・配信を1か所で順番に処理する
+func (h *Hub) Run() {
+	for {
+		select {
+		case c := <-h.unregister:
+			h.remove(c)
+		case op := <-h.ops:
+			op()
+		}
+	}
+}
+
+// 接続を追加する（所属ルームは読み込みが終わってから finishLoading で入れる）
+func (h *Hub) add(reg *registration) {
+	c := reg.client
+	clients := h.users[c.UserID]
+	if clients == nil {
+		clients = make(map[*Client]bool)
+		h.users[c.UserID] = clients
+	}
+	clients[c] = true
+
+	if h.loading[c.UserID] == nil {
+		h.loading[c.UserID] = make(map[*registration]bool)
+	}
+	h.loading[c.UserID][reg] = true
+}
+
+// 読み込んだ所属ルームを配信先に入れる（読み込み中に外されたルームは除く。err があれば接続ごと外す）
+func (h *Hub) finishLoading(reg *registration, roomIDs []int, err error) {
+	c := reg.client
+	delete(h.loading[c.UserID], reg)
+	if len(h.loading[c.UserID]) == 0 {
+		delete(h.loading, c.UserID)
+	}
+	if err != nil {
+		h.remove(c)
+		return
+	}
+	if !h.users[c.UserID][c] {
+		return // 読み込み中に切断された
+	}
+	for _, roomID := range roomIDs {
+		if !reg.removed[roomID] {
+			h.addMember(roomID, c.UserID)
+		}
+	}
+}
+
+// 接続を外して送信キューを閉じる（最後の接続ならルームの配信先からも外す）
+func (h *Hub) remove(c *Client) {
+	clients := h.users[c.UserID]
+	if !clients[c] {
+		return
+	}
+	delete(clients, c)
+	close(c.send)
+
+	for roomID := range c.subs {
+		h.unsubscribe(c, roomID)
+	}
+
+	if len(clients) == 0 {
+		delete(h.users, c.UserID)
+		for roomID := range h.userRooms[c.UserID] {
+			delete(h.rooms[roomID], c.UserID)
+			if len(h.rooms[roomID]) == 0 {
+				delete(h.rooms, roomID)
+			}
+		}
+		delete(h.userRooms, c.UserID)
+	}
+}
+
+func (h *Hub) addMember(roomID, userID int) {
+	if h.users[userID] == nil {
+		return // 接続していないユーザーは覚えなくてよい
+	}
+	if h.rooms[roomID] == nil {
+		h.rooms[roomID] = make(map[int]bool)
+	}
+	h.rooms[roomID][userID] = true
+	for reg := range h.loading[userID] {
+		delete(reg.removed, roomID) // 外された後にまた加わった
+	}
+	if h.userRooms[userID] == nil {
+		h.userRooms[userID] = make(map[int]bool)
+	}
+	h.userRooms[userID][roomID] = true
+}
+
+func (h *Hub) removeMember(roomID, userID int) {
+	delete(h.rooms[roomID], userID)
+	if len(h.rooms[roomID]) == 0 {
+		delete(h.rooms, roomID)
+	}
+	delete(h.userRooms[userID], roomID)
+	for reg := range h.loading[userID] {
+		reg.removed[roomID] = true
+	}
+	for c := range h.users[userID] {
+		h.unsubscribe(c, roomID)
+	}
+}
+
+func (h *Hub) unsubscribe(c *Client, roomID int) {
+	delete(c.subs, roomID)
+	delete(h.subscribers[roomID], c)
+	if len(h.subscribers[roomID]) == 0 {
+		delete(h.subscribers, roomID)
+	}
+}
+
+// 送信キューに積む（ブロックしない）
+func (h *Hub) deliver(c *Client, data []byte) {
+	select {
+	case c.send <- data:
+	default:
+		// 送信キューが詰まっている遅いクライアントは切断する
+		log.Println("⚠️ send buffer full, dropping client of user:", c.UserID)
+		h.remove(c)
+	}
+}
+
+// JSONにしてから ops に積む（Run の外でエンコードしておく）
+func (h *Hub) enqueue(v interface{}, op func(data []byte)) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Println("❌ broadcast marshal error:", err)
+		return
+	}
+	h.ops <- func() { op(data) }
+}
+
+// 接続を登録し、所属ルームのイベントが届くようにする（エラーなら登録しない）
+// loadRooms（DBの読み込み）は呼び出し元のゴルーチンで、接続を登録した後に呼ぶ
+// そのため読み込み中の AddMember はそのまま反映され、RemoveMember は読み込み結果から除かれる
+func (h *Hub) Register(c *Client, loadRooms func() ([]int, error)) error {
+	reg := &registration{client: c, removed: make(map[int]bool)}
+	added := make(chan struct{})
+	h.ops <- func() {
+		h.add(reg)
+		close(added)
+	}
+	<-added
+
+	roomIDs, err := loadRooms()
+	done := make(chan struct{})
+	h.ops <- func() {
+		h.finishLoading(reg, roomIDs, err)
+		close(done)
+	}
+	<-done
+	return err
+}
+
+// 接続を登録解除
+func (h *Hub) Unregister(c *Client) {
+	h.unregister <- c
+}
+
+// ルームにメンバーが加わった（接続中ならすぐ配信対象になる）
+func (h *Hub) AddMember(roomID, userID int) {
+	h.ops <- func() { h.addMember(roomID, userID) }
+}
+
+// ルームからメンバーが抜けた（購読も解除される）
+func (h *Hub) RemoveMember(roomID, userID int) {
+	h.ops <- func() { h.removeMember(roomID, userID) }
+}
+
+// 接続がルームの詳細イベントを購読する（メンバーかどうかは呼び出し側で確認済みの前提）
+func (h *Hub) Subscribe(c *Client, roomID int) {
+	h.ops <- func() {
+		if !h.users[c.UserID][c] {
+			return
+		}
+		h.addMember(roomID, c.UserID)
+		if h.subscribers[roomID] == nil {
+			h.subscribers[roomID] = make(map[*Client]bool)
+		}
+		h.subscribers[roomID][c] = true
+		c.subs[roomID] = true
+	}
+}
+
+// 購読をやめる
+func (h *Hub) Unsubscribe(c *Client, roomID int) {
+	h.ops <- func() { h.unsubscribe(c, roomID) }
+}
+
+// ルームのメンバー全員の全接続に送信（exclude が nil でなければその接続は除外）
+func (h *Hub) BroadcastToRoom(roomID int, v interface{}, exclude *Client) {
+	h.enqueue(v, func(data []byte) {
+		for userID := range h.rooms[roomID] {
+			for c := range h.users[userID] {
+				if c != exclude {
+					h.deliver(c, data)
+				}
+			}
+		}
+	})
+}
+
+// ルームを購読している接続だけに送信（入力中・既読などの詳細イベント用）
+func (h *Hub) BroadcastToSubscribers(roomID int, v interface{}, exclude *Client) {
+	h.enqueue(v, func(data []byte) {
+		for c := range h.subscribers[roomID] {
+			if c != exclude {
+				h.deliver(c, data)
+			}
+		}
+	})
+}
+
+// 特定ユーザーの全接続に送信（メンションなど本人宛ての通知用）
+func (h *Hub) SendToUser(userID int, v interface{}) {
+	h.enqueue(v, func(data []byte) {
+		for c := range h.users[userID] {
+			h.deliver(c, data)
+		}
+	})
+}
+
+// 特定の接続だけに送信（ACKやエラー通知用）
+func (h *Hub) SendTo(c *Client, v interface{}) {
+	h.enqueue(v, func(data []byte) {
+		if h.users[c.UserID][c] {
+			h.deliver(c, data)
+		}
+	})
+}
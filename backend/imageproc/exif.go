@@ -0,0 +1,100 @@
+package imageproc
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// EXIF の Orientation タグ
+const orientationTag = 0x0112
+
+// JPEG の APP1（Exif）から Orientation を読む（見つからなければ 1＝そのまま）
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		if marker == 0xD8 || (marker >= 0xD0 && marker <= 0xD7) || marker == 0x01 {
+			i += 2 // 長さを持たないマーカー
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			return 1 // 画像データ本体に入ったので終わり
+		}
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + size
+		if size < 2 || end > len(data) {
+			return 1
+		}
+		if seg := data[i+4 : end]; marker == 0xE1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			return tiffOrientation(seg[6:])
+		}
+		i = end
+	}
+	return 1
+}
+
+// PNG の eXIf チャンクから Orientation を読む（見つからなければ 1）
+func pngOrientation(data []byte) int {
+	const sig = "\x89PNG\r\n\x1a\n"
+	if !bytes.HasPrefix(data, []byte(sig)) {
+		return 1
+	}
+	for i := len(sig); i+8 <= len(data); {
+		size := int(binary.BigEndian.Uint32(data[i:]))
+		typ := string(data[i+4 : i+8])
+		end := i + 8 + size
+		if size < 0 || end+4 > len(data) {
+			return 1
+		}
+		switch typ {
+		case "eXIf":
+			return tiffOrientation(data[i+8 : end])
+		case "IDAT", "IEND":
+			return 1 // eXIf は画像データより前にしか置けない
+		}
+		i = end + 4 // CRC
+	}
+	return 1
+}
+
+// TIFF 形式の EXIF の IFD0 から Orientation を探す
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for n := 0; n < count; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) != orientationTag {
+			continue
+		}
+		// 型は SHORT、値はエントリ内に直接入っている
+		if v := int(order.Uint16(tiff[entry+8:])); v >= 1 && v <= 8 {
+			return v
+		}
+		return 1
+	}
+	return 1
+}
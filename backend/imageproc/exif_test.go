@@ -0,0 +1,109 @@
+package imageproc
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+)
+
+// Orientation だけを持つ IFD0 の TIFF データ
+func tiffWithOrientation(order binary.ByteOrder, orientation int) []byte {
+	b := make([]byte, 8+2+12+4)
+	if order == binary.LittleEndian {
+		copy(b, "II")
+	} else {
+		copy(b, "MM")
+	}
+	order.PutUint16(b[2:], 42)
+	order.PutUint32(b[4:], 8) // IFD0 の位置
+	order.PutUint16(b[8:], 1) // エントリ数
+	entry := b[10:]
+	order.PutUint16(entry[0:], orientationTag)
+	order.PutUint16(entry[2:], 3) // SHORT
+	order.PutUint32(entry[4:], 1)
+	order.PutUint16(entry[8:], uint16(orientation))
+	return b
+}
+
+// SOI の直後に APP1（Exif）を置いた JPEG の先頭部分
+func jpegWithExif(tiff []byte) []byte {
+	seg := append([]byte("Exif\x00\x00"), tiff...)
+	b := []byte{0xFF, 0xD8, 0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(b[4:], uint16(len(seg)+2))
+	b = append(b, seg...)
+	return append(b, 0xFF, 0xDA, 0, 2) // SOS
+}
+
+func pngChunk(typ string, data []byte) []byte {
+	b := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(b, uint32(len(data)))
+	copy(b[4:], typ)
+	b = append(b, data...)
+	crc := crc32.ChecksumIEEE(b[4:])
+	return binary.BigEndian.AppendUint32(b, crc)
+}
+
+// IHDR の後に eXIf を置いた PNG の先頭部分
+func pngWithExif(tiff []byte) []byte {
+	b := []byte("\x89PNG\r\n\x1a\n")
+	b = append(b, pngChunk("IHDR", make([]byte, 13))...)
+	b = append(b, pngChunk("eXIf", tiff)...)
+	return append(b, pngChunk("IEND", nil)...)
+}
+
+func TestOrientation(t *testing.T) {
+	orders := []struct {
+		name  string
+		order binary.ByteOrder
+	}{
+		{"little endian", binary.LittleEndian},
+		{"big endian", binary.BigEndian},
+	}
+	for _, o := range orders {
+		for orientation := 1; orientation <= 8; orientation++ {
+			tiff := tiffWithOrientation(o.order, orientation)
+			if got := tiffOrientation(tiff); got != orientation {
+				t.Errorf("%s: tiffOrientation = %d, want %d", o.name, got, orientation)
+			}
+			if got := jpegOrientation(jpegWithExif(tiff)); got != orientation {
+				t.Errorf("%s: jpegOrientation = %d, want %d", o.name, got, orientation)
+			}
+			if got := pngOrientation(pngWithExif(tiff)); got != orientation {
+				t.Errorf("%s: pngOrientation = %d, want %d", o.name, got, orientation)
+			}
+		}
+	}
+}
+
+func TestOrientationInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		fn   func([]byte) int
+	}{
+		{"out of range", tiffWithOrientation(binary.BigEndian, 9), tiffOrientation},
+		{"zero", tiffWithOrientation(binary.BigEndian, 0), tiffOrientation},
+		{"bad byte order", append([]byte("XX"), tiffWithOrientation(binary.BigEndian, 6)[2:]...), tiffOrientation},
+		{"truncated tiff", tiffWithOrientation(binary.LittleEndian, 6)[:12], tiffOrientation},
+		{"not a jpeg", []byte("not a jpeg"), jpegOrientation},
+		{"jpeg without exif", []byte{0xFF, 0xD8, 0xFF, 0xDA, 0, 2}, jpegOrientation},
+		{"truncated jpeg segment", jpegWithExif(tiffWithOrientation(binary.BigEndian, 6))[:10], jpegOrientation},
+		{"not a png", []byte("not a png"), pngOrientation},
+		{"exif after IDAT", append(append([]byte("\x89PNG\r\n\x1a\n"), pngChunk("IDAT", nil)...),
+			pngChunk("eXIf", tiffWithOrientation(binary.BigEndian, 6))...), pngOrientation},
+	}
+	for _, tt := range tests {
+		if got := tt.fn(tt.data); got != 1 {
+			t.Errorf("%s: got %d, want 1", tt.name, got)
+		}
+	}
+}
+
+func TestJPEGOrientationSkipsOtherSegments(t *testing.T) {
+	app0 := []byte{0xFF, 0xE0, 0, 4, 'J', 'F'}
+	data := jpegWithExif(tiffWithOrientation(binary.LittleEndian, 6))
+	data = append(append(append([]byte{}, data[:2]...), app0...), data[2:]...)
+	if got := jpegOrientation(data); got != 6 {
+		t.Errorf("got %d, want 6", got)
+	}
+}
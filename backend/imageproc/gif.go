@@ -0,0 +1,63 @@
+package imageproc
+
+import "encoding/binary"
+
+// GIF 全体で展開してよいフレーム数の上限（フレームの面積の合計は maxPixels まで）
+const maxGIFFrames = 1000
+
+// デコードせずにブロックをたどり、全フレームの枚数と面積の合計が上限内か確かめる
+// 論理画面が小さくても、全面フレームを大量に並べると展開後は何GBにもなるため
+func checkGIFFrames(data []byte) error {
+	// ヘッダ（6）＋論理画面記述子（7）
+	if len(data) < 13 {
+		return ErrUnsupported
+	}
+	p := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		p += 3 << (flags&0x07 + 1) // グローバルカラーテーブル
+	}
+
+	frames, area := 0, 0
+	for p < len(data) {
+		switch data[p] {
+		case 0x2C: // イメージ記述子
+			if p+10 > len(data) {
+				return ErrUnsupported
+			}
+			w := int(binary.LittleEndian.Uint16(data[p+5:]))
+			h := int(binary.LittleEndian.Uint16(data[p+7:]))
+			frames++
+			area += w * h
+			if frames > maxGIFFrames || area > maxPixels {
+				return ErrTooLarge
+			}
+			flags := data[p+9]
+			p += 10
+			if flags&0x80 != 0 {
+				p += 3 << (flags&0x07 + 1) // ローカルカラーテーブル
+			}
+			p++ // LZW の最小コードサイズ
+			p = skipGIFSubBlocks(data, p)
+		case 0x21: // 拡張ブロック（ラベルの後にサブブロックが続く）
+			p = skipGIFSubBlocks(data, p+2)
+		case 0x3B: // トレーラ
+			return nil
+		default:
+			return ErrUnsupported
+		}
+	}
+	return nil // 途中で切れている場合はデコーダがエラーにする
+}
+
+// サブブロックの並び（長さ0のブロックで終わる）を読み飛ばす
+func skipGIFSubBlocks(data []byte, p int) int {
+	for p < len(data) {
+		n := int(data[p])
+		p++
+		if n == 0 {
+			break
+		}
+		p += n
+	}
+	return p
+}
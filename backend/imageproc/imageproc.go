@@ -0,0 +1,219 @@
+// Package imageproc は、アップロードされた画像からメタデータを取り除き、
+// 向きを揃え、サムネイルを作る（外部コマンドを使わない pure Go 実装）。
+package imageproc
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+const (
+	jpegQuality = 90
+	maxPixels   = 50_000_000 // 展開後に巨大になる画像（解凍爆弾）を弾く。GIF は全フレームの合計
+)
+
+var (
+	ErrUnsupported = errors.New("unsupported or corrupt image") // 対応していない形式か、デコードできない
+	ErrTooLarge    = errors.New("image dimensions are too large")
+)
+
+// 処理済みの画像（元画像・サムネイル共通）
+type Image struct {
+	Data     []byte
+	MimeType string
+	Ext      string
+	Width    int
+	Height   int
+}
+
+// Process の結果
+type Result struct {
+	Original   Image         // メタデータを除去し、向きを正した元画像
+	Thumbnails map[int]Image // 長辺のサイズ → サムネイル（元画像より小さいサイズだけ）
+}
+
+// 画像を処理する。sizes はサムネイルの長辺のピクセル数
+// JPEG・PNG・GIF・WebP に対応し、GIF と WebP のサムネイルは PNG で作る（GIF は最初のフレームから）
+// WebP はエンコーダがないため、メタデータのチャンクだけを取り除いて元のデータを残す
+func Process(data []byte, sizes []int) (*Result, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return nil, ErrTooLarge
+	}
+
+	var res Result
+	var frame image.Image // サムネイルの元にする画像
+
+	switch format {
+	case "jpeg":
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, ErrUnsupported
+		}
+		frame = orient(img, jpegOrientation(data))
+		if res.Original, err = encodeJPEG(frame); err != nil {
+			return nil, err
+		}
+
+	case "png":
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, ErrUnsupported
+		}
+		frame = orient(img, pngOrientation(data))
+		if res.Original, err = encodePNG(frame); err != nil {
+			return nil, err
+		}
+
+	case "gif":
+		// アニメーションはそのまま残し、コメントなどの拡張ブロックだけ落とす
+		if err := checkGIFFrames(data); err != nil {
+			return nil, err
+		}
+		anim, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return nil, ErrUnsupported
+		}
+		if len(anim.Image) == 0 {
+			return nil, ErrUnsupported
+		}
+		anim.Config.Width, anim.Config.Height = cfg.Width, cfg.Height
+		var buf bytes.Buffer
+		if err := gif.EncodeAll(&buf, anim); err != nil {
+			return nil, err
+		}
+		res.Original = Image{Data: buf.Bytes(), MimeType: "image/gif", Ext: ".gif", Width: cfg.Width, Height: cfg.Height}
+		frame = firstFrame(anim, cfg.Width, cfg.Height)
+
+	case "webp":
+		img, err := webp.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, ErrUnsupported // アニメーション WebP はデコードできない
+		}
+		stripped, err := stripWebPMetadata(data)
+		if err != nil {
+			return nil, err
+		}
+		res.Original = Image{Data: stripped, MimeType: "image/webp", Ext: ".webp", Width: cfg.Width, Height: cfg.Height}
+		frame = img
+
+	default:
+		return nil, ErrUnsupported
+	}
+
+	res.Thumbnails = make(map[int]Image, len(sizes))
+	for _, size := range sizes {
+		b := frame.Bounds()
+		if size <= 0 || (b.Dx() <= size && b.Dy() <= size) {
+			continue // 拡大はしない
+		}
+		thumb := resize(frame, size)
+		var t Image
+		if format == "jpeg" {
+			t, err = encodeJPEG(thumb)
+		} else {
+			t, err = encodePNG(thumb)
+		}
+		if err != nil {
+			return nil, err
+		}
+		res.Thumbnails[size] = t
+	}
+	return &res, nil
+}
+
+func encodeJPEG(img image.Image) (Image, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return Image{}, err
+	}
+	b := img.Bounds()
+	return Image{Data: buf.Bytes(), MimeType: "image/jpeg", Ext: ".jpg", Width: b.Dx(), Height: b.Dy()}, nil
+}
+
+func encodePNG(img image.Image) (Image, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return Image{}, err
+	}
+	b := img.Bounds()
+	return Image{Data: buf.Bytes(), MimeType: "image/png", Ext: ".png", Width: b.Dx(), Height: b.Dy()}, nil
+}
+
+// 長辺が size になるよう縮小（縦横比は保つ）
+func resize(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := size, size
+	if b.Dx() >= b.Dy() {
+		h = max(1, b.Dy()*size/b.Dx())
+	} else {
+		w = max(1, b.Dx()*size/b.Dy())
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// GIF の最初のフレームを画面サイズのキャンバスに描く（フレームは画面の一部だけのこともある）
+func firstFrame(anim *gif.GIF, width, height int) image.Image {
+	canvas := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(color.Transparent), image.Point{}, draw.Src)
+	frame := anim.Image[0]
+	draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+	return canvas
+}
+
+// EXIF の Orientation（1〜8）に従って、表示される向きの画像にする
+func orient(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	src := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	w, h := b.Dx(), b.Dy()
+
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w // 90度回転を含むものは縦横が入れ替わる
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // 左右反転
+				sx, sy = w-1-x, y
+			case 3: // 180度回転
+				sx, sy = w-1-x, h-1-y
+			case 4: // 上下反転
+				sx, sy = x, h-1-y
+			case 5: // 転置
+				sx, sy = y, x
+			case 6: // 時計回りに90度
+				sx, sy = y, h-1-x
+			case 7: // 反転転置
+				sx, sy = w-1-y, h-1-x
+			case 8: // 反時計回りに90度
+				sx, sy = w-1-y, x
+			}
+			si := src.PixOffset(sx, sy)
+			di := dst.PixOffset(x, y)
+			copy(dst.Pix[di:di+4], src.Pix[si:si+4])
+		}
+	}
+	return dst
+}
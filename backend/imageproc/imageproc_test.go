@@ -0,0 +1,236 @@
+package imageproc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// 3×2 の画像。各ピクセルの R に a〜f の番号を入れる
+//
+//	a b c
+//	d e f
+func labeledImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8('a' + y*3 + x), A: 255})
+		}
+	}
+	return img
+}
+
+// 画像の R を行ごとの文字列にする
+func labels(img image.Image) []string {
+	b := img.Bounds()
+	rows := make([]string, 0, b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		var row []byte
+		for x := b.Min.X; x < b.Max.X; x++ {
+			row = append(row, byte(color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA).R))
+		}
+		rows = append(rows, string(row))
+	}
+	return rows
+}
+
+func TestOrient(t *testing.T) {
+	tests := []struct {
+		orientation int
+		want        []string
+	}{
+		{1, []string{"abc", "def"}},
+		{2, []string{"cba", "fed"}},
+		{3, []string{"fed", "cba"}},
+		{4, []string{"def", "abc"}},
+		{5, []string{"ad", "be", "cf"}},
+		{6, []string{"da", "eb", "fc"}},
+		{7, []string{"fc", "eb", "da"}},
+		{8, []string{"cf", "be", "ad"}},
+		{0, []string{"abc", "def"}},
+		{9, []string{"abc", "def"}},
+	}
+	for _, tt := range tests {
+		got := labels(orient(labeledImage(), tt.orientation))
+		if len(got) != len(tt.want) {
+			t.Errorf("orientation %d: got %q, want %q", tt.orientation, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("orientation %d: got %q, want %q", tt.orientation, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestResize(t *testing.T) {
+	tests := []struct {
+		w, h, size   int
+		wantW, wantH int
+	}{
+		{400, 200, 100, 100, 50},
+		{200, 400, 100, 50, 100},
+		{300, 300, 64, 64, 64},
+		{1000, 1, 100, 100, 1}, // 短辺は 1 未満にしない
+		{1, 1000, 100, 1, 100},
+	}
+	for _, tt := range tests {
+		img := image.NewNRGBA(image.Rect(0, 0, tt.w, tt.h))
+		b := resize(img, tt.size).Bounds()
+		if b.Dx() != tt.wantW || b.Dy() != tt.wantH {
+			t.Errorf("resize(%dx%d, %d) = %dx%d, want %dx%d", tt.w, tt.h, tt.size, b.Dx(), b.Dy(), tt.wantW, tt.wantH)
+		}
+	}
+}
+
+func encodeTestJPEG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, w, h)), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProcessJPEG(t *testing.T) {
+	// SOI の直後に Orientation=6（時計回りに90度）の EXIF を差し込む
+	plain := encodeTestJPEG(t, 40, 20)
+	exif := jpegWithExif(tiffWithOrientation(binary.BigEndian, 6))
+	data := append(append([]byte{}, exif[:len(exif)-4]...), plain[2:]...)
+
+	res, err := Process(data, []int{16, 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Original.Width != 20 || res.Original.Height != 40 {
+		t.Errorf("original = %dx%d, want 20x40", res.Original.Width, res.Original.Height)
+	}
+	if bytes.Contains(res.Original.Data, []byte("Exif\x00\x00")) {
+		t.Error("EXIF should be stripped from the original")
+	}
+	if res.Original.MimeType != "image/jpeg" || res.Original.Ext != ".jpg" {
+		t.Errorf("original type = %s %s", res.Original.MimeType, res.Original.Ext)
+	}
+
+	thumb, ok := res.Thumbnails[16]
+	if !ok {
+		t.Fatal("missing 16px thumbnail")
+	}
+	if thumb.Width != 8 || thumb.Height != 16 {
+		t.Errorf("thumbnail = %dx%d, want 8x16", thumb.Width, thumb.Height)
+	}
+	if _, ok := res.Thumbnails[100]; ok {
+		t.Error("thumbnail larger than the original should be skipped")
+	}
+}
+
+func TestProcessPNG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, labeledImage()); err != nil {
+		t.Fatal(err)
+	}
+	res, err := Process(buf.Bytes(), []int{2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Original.Width != 3 || res.Original.Height != 2 || res.Original.MimeType != "image/png" {
+		t.Errorf("original = %dx%d %s", res.Original.Width, res.Original.Height, res.Original.MimeType)
+	}
+	if thumb := res.Thumbnails[2]; thumb.Width != 2 || thumb.Height != 1 || thumb.MimeType != "image/png" {
+		t.Errorf("thumbnail = %dx%d %s", thumb.Width, thumb.Height, thumb.MimeType)
+	}
+}
+
+func TestProcessErrors(t *testing.T) {
+	// 画面サイズだけが巨大な PNG（IHDR だけ）
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], 10000)
+	binary.BigEndian.PutUint32(ihdr[4:], 10000)
+	ihdr[8], ihdr[9] = 8, 6 // 8bit RGBA
+	huge := append([]byte("\x89PNG\r\n\x1a\n"), pngChunk("IHDR", ihdr)...)
+
+	jpegData := encodeTestJPEG(t, 10, 10)
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"not an image", []byte("hello"), ErrUnsupported},
+		{"truncated jpeg", jpegData[:len(jpegData)/2], ErrUnsupported},
+		{"too many pixels", huge, ErrTooLarge},
+	}
+	for _, tt := range tests {
+		if _, err := Process(tt.data, nil); err != tt.want {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+// 1×1 の画面に w×h のフレームを n 枚並べた GIF（フレームの中身は空）
+func gifWithFrames(n, w, h int) []byte {
+	b := []byte("GIF89a")
+	b = append(b, 1, 0, 1, 0, 0, 0, 0) // 画面 1×1、グローバルカラーテーブルなし
+	for i := 0; i < n; i++ {
+		b = append(b, 0x2C, 0, 0, 0, 0)
+		b = binary.LittleEndian.AppendUint16(b, uint16(w))
+		b = binary.LittleEndian.AppendUint16(b, uint16(h))
+		b = append(b, 0, 2, 0) // フラグ、LZW の最小コードサイズ、サブブロックの終わり
+	}
+	return append(b, 0x3B)
+}
+
+func TestCheckGIFFrames(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"few small frames", gifWithFrames(3, 10, 10), nil},
+		{"frame limit", gifWithFrames(maxGIFFrames, 1, 1), nil},
+		{"too many frames", gifWithFrames(maxGIFFrames+1, 1, 1), ErrTooLarge},
+		{"area within limit", gifWithFrames(3, 4000, 4000), nil},
+		{"total area too large", gifWithFrames(4, 4000, 4000), ErrTooLarge},
+		{"unknown block", append(gifWithFrames(0, 0, 0)[:13], 0x99), ErrUnsupported},
+	}
+	for _, tt := range tests {
+		if err := checkGIFFrames(tt.data); err != tt.want {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestProcessGIF(t *testing.T) {
+	palette := color.Palette{color.Black, color.White}
+	anim := &gif.GIF{}
+	for i := 0; i < 3; i++ {
+		anim.Image = append(anim.Image, image.NewPaletted(image.Rect(0, 0, 20, 10), palette))
+		anim.Delay = append(anim.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := Process(buf.Bytes(), []int{10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := gif.DecodeAll(bytes.NewReader(res.Original.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Image) != 3 {
+		t.Errorf("frames = %d, want 3", len(out.Image))
+	}
+	if thumb := res.Thumbnails[10]; thumb.Width != 10 || thumb.Height != 5 || thumb.MimeType != "image/png" {
+		t.Errorf("thumbnail = %dx%d %s", thumb.Width, thumb.Height, thumb.MimeType)
+	}
+}
@@ -0,0 +1,54 @@
+package imageproc
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// VP8X チャンクのフラグ
+const (
+	webpFlagEXIF = 0x08
+	webpFlagXMP  = 0x04
+)
+
+// WebP（RIFF）から EXIF・XMP チャンクを取り除く。画像データは再エンコードせずそのまま残す
+func stripWebPMetadata(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, ErrUnsupported
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:12]) // RIFF の長さは最後に書き直す
+	for p := 12; p < len(data); {
+		if p+8 > len(data) {
+			return nil, ErrUnsupported
+		}
+		fourCC := string(data[p : p+4])
+		size := int(binary.LittleEndian.Uint32(data[p+4:]))
+		end := p + 8 + size
+		if end > len(data) {
+			return nil, ErrUnsupported
+		}
+		if size%2 == 1 && end < len(data) {
+			end++ // チャンクは偶数バイトに揃えてある
+		}
+
+		switch fourCC {
+		case "EXIF", "XMP ":
+			// 位置情報などを含むので落とす
+		case "VP8X":
+			chunk := append([]byte(nil), data[p:end]...)
+			if len(chunk) > 8 {
+				chunk[8] &^= webpFlagEXIF | webpFlagXMP
+			}
+			out.Write(chunk)
+		default:
+			out.Write(data[p:end])
+		}
+		p = end
+	}
+
+	res := out.Bytes()
+	binary.LittleEndian.PutUint32(res[4:], uint32(len(res)-8))
+	return res, nil
+}
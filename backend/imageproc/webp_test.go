@@ -0,0 +1,84 @@
+package imageproc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func riffChunk(fourCC string, data []byte) []byte {
+	b := append([]byte(fourCC), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(data)))
+	b = append(b, data...)
+	if len(data)%2 == 1 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func riff(chunks ...[]byte) []byte {
+	b := []byte("RIFF\x00\x00\x00\x00WEBP")
+	for _, c := range chunks {
+		b = append(b, c...)
+	}
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(b)-8))
+	return b
+}
+
+func TestStripWebPMetadata(t *testing.T) {
+	const flagAlpha = 0x10
+	vp8x := make([]byte, 10)
+	vp8x[0] = flagAlpha | webpFlagEXIF | webpFlagXMP
+	vp8xStripped := make([]byte, 10)
+	vp8xStripped[0] = flagAlpha
+	image := []byte{1, 2, 3} // 奇数長（パディングあり）
+
+	tests := []struct {
+		name string
+		data []byte
+		want []byte
+	}{
+		{
+			"exif and xmp",
+			riff(riffChunk("VP8X", vp8x), riffChunk("VP8 ", image), riffChunk("EXIF", []byte("exif")), riffChunk("XMP ", []byte("<x/>!"))),
+			riff(riffChunk("VP8X", vp8xStripped), riffChunk("VP8 ", image)),
+		},
+		{
+			"no metadata",
+			riff(riffChunk("VP8 ", image)),
+			riff(riffChunk("VP8 ", image)),
+		},
+		{
+			"unpadded last chunk",
+			riff(riffChunk("VP8 ", image), riffChunk("EXIF", []byte("e")))[:12+len(riffChunk("VP8 ", image))+9],
+			riff(riffChunk("VP8 ", image)),
+		},
+	}
+	for _, tt := range tests {
+		got, err := stripWebPMetadata(tt.data)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("%s:\n got  %q\n want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStripWebPMetadataInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"not riff", []byte("RIFX\x00\x00\x00\x00WEBP")},
+		{"not webp", []byte("RIFF\x00\x00\x00\x00WAVE")},
+		{"truncated chunk header", append(riff(), 'V', 'P', '8')},
+		{"chunk past end", riff(riffChunk("VP8 ", make([]byte, 8)))[:20]},
+	}
+	for _, tt := range tests {
+		if _, err := stripWebPMetadata(tt.data); err != ErrUnsupported {
+			t.Errorf("%s: err = %v, want ErrUnsupported", tt.name, err)
+		}
+	}
+}
@@ -1,48 +1,60 @@
 package main
 
 import (
+	"backend/auth"    // 認証ミドルウェア
 	"backend/handler" // ハンドラーパッケージ
 	"fmt"
 	"log"
 	"net/http"
-	"strings"
 )
 
 func main() {
 	// PostgreSQL接続
 	handler.InitDB()
 
-	// --- 静的ファイル（画像アップロード） ---
-	http.Handle("/uploads/", http.StripPrefix("/uploads/", http.FileServer(http.Dir("public/uploads"))))
+	// WebSocket Hub 起動
+	handler.InitHub()
+
+	// ファイルの保存先（ローカルディスク or S3互換ストレージ）
+	handler.InitStorage()
+
+	// --- アップロードファイル（保存先から返す） ---
+	http.HandleFunc("/uploads/", handler.FileHandler("uploads"))
+	http.HandleFunc("/images/", handler.FileHandler("images")) // プロフィール画像
 
 	// --- 認証・ユーザー関連 ---
 	http.HandleFunc("/signup", handler.WithCORS(handler.SignupHandler))
 	http.HandleFunc("/login", handler.WithCORS(handler.LoginHandler))
-	http.HandleFunc("/users", handler.WithCORS(handler.GetUsersHandler))
-	http.HandleFunc("/delete", handler.WithCORS(handler.DeleteUserHandler))
-	http.HandleFunc("/api/profile", handler.WithCORS(handler.UpdateProfileHandler))
+	http.HandleFunc("/users", handler.WithCORS(auth.Require(handler.GetUsersHandler)))
+	http.HandleFunc("/delete", handler.WithCORS(auth.Require(handler.DeleteUserHandler)))
+	http.HandleFunc("/api/profile", handler.WithCORS(auth.Require(handler.UpdateProfileHandler)))
+	http.HandleFunc("/api/profile/", handler.WithCORS(auth.Require(handler.GetProfileHandler))) // /api/profile/{id}
+	http.HandleFunc("/api/me", handler.WithCORS(auth.Require(handler.GetMeHandler)))
 
 	// --- チャットルーム関連 ---
-	http.HandleFunc("/start_chat", handler.WithCORS(handler.StartChatHandler))
-	http.HandleFunc("/create_group", handler.WithCORS(handler.CreateGroupHandler))
-	http.HandleFunc("/my_rooms", handler.WithCORS(handler.GetMyRoomsHandler))
-	http.HandleFunc("/room_members", handler.WithCORS(handler.GetRoomMembersHandler))
+	http.HandleFunc("/start_chat", handler.WithCORS(auth.Require(handler.StartChatHandler)))
+	http.HandleFunc("/create_group", handler.WithCORS(auth.Require(handler.CreateGroupHandler)))
+	http.HandleFunc("/my_rooms", handler.WithCORS(auth.Require(handler.GetMyRoomsHandler)))
+	http.HandleFunc("/room_members", handler.WithCORS(auth.Require(handler.GetRoomMembersHandler)))
+	http.HandleFunc("/mark_read", handler.WithCORS(auth.Require(handler.MarkReadHandler)))
+	http.HandleFunc("/rooms/", handler.WithCORS(auth.Require(handler.RoomsByIDRouter))) // 名前変更・招待・メンバー削除・退出・オーナー譲渡・招待リンク
+	http.HandleFunc("/invitations", handler.WithCORS(auth.Require(handler.MyInvitationsHandler)))
+	http.HandleFunc("/invitations/", handler.WithCORS(auth.Require(handler.InvitationsByIDRouter)))     // 承諾・辞退
+	http.HandleFunc("/invite_links/", handler.WithCORS(auth.Require(handler.InviteLinksByTokenRouter))) // プレビュー・参加
 
 	// --- メッセージ関連 ---
-	http.HandleFunc("/messages", handler.WithCORS(handler.MessagesRouter))
-	http.HandleFunc("/messages/", handler.WithCORS(func(w http.ResponseWriter, r *http.Request) {
-		if strings.HasSuffix(r.URL.Path, "/hide") {
-			handler.HideMessageForUser(w, r)
-		} else {
-			handler.MessagesByIDRouter(w, r)
-		}
-	}))
+	http.HandleFunc("/messages", handler.WithCORS(auth.Require(handler.MessagesRouter)))
+	http.HandleFunc("/messages/", handler.WithCORS(auth.Require(handler.MessagesByIDRouter))) // 編集・削除・非表示・リアクション・ピン留めなど
+	http.HandleFunc("/pinned_messages", handler.WithCORS(auth.Require(handler.GetPinnedMessagesHandler)))
+
+	// --- 検索 ---
+	http.HandleFunc("/search", handler.WithCORS(auth.Require(handler.SearchHandler)))
 
 	// --- その他 ---
-	http.HandleFunc("/upload", handler.WithCORS(handler.UploadImageHandler))
-	http.HandleFunc("/ws", handler.WebSocketHandler) // WebSocketはCORS不要
+	http.HandleFunc("/upload", handler.WithCORS(auth.Require(handler.UploadImageHandler)))
+	http.Handle("/ws", auth.WebSocketMiddleware(http.HandlerFunc(handler.WebSocketHandler))) // WebSocketはCORS不要（トークンは ?token=）
 	// --- チャットルーム関連 ---
-	http.HandleFunc("/delete_room", handler.WithCORS(handler.DeleteRoomHandler))
+	http.HandleFunc("/delete_room", handler.WithCORS(auth.Require(handler.DeleteRoomHandler)))
 
 	// サーバーログ表示
 	fmt.Println("Server started at http://localhost:8081")
@@ -0,0 +1,86 @@
+package presence
+
+import "sync"
+
+// ユーザーの状態
+const (
+	Online  = "online"
+	Away    = "away"
+	Offline = "offline"
+)
+
+type userState struct {
+	conns int  // 開いているWebSocketの数（タブや端末ごとに1本）
+	away  bool // クライアントから away を通知された
+}
+
+// Tracker：ユーザーごとの接続数と状態を管理する
+type Tracker struct {
+	mu    sync.Mutex
+	users map[int]*userState
+}
+
+// 新しいTrackerを作成
+func New() *Tracker {
+	return &Tracker{users: make(map[int]*userState)}
+}
+
+// 接続が1本増えた。オフラインからオンラインになったら true
+func (t *Tracker) Connect(userID int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	st, ok := t.users[userID]
+	if !ok {
+		st = &userState{}
+		t.users[userID] = st
+	}
+	st.conns++
+	return st.conns == 1
+}
+
+// 接続が1本減った。最後の接続が切れてオフラインになったら true
+func (t *Tracker) Disconnect(userID int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	st, ok := t.users[userID]
+	if !ok {
+		return false
+	}
+	st.conns--
+	if st.conns > 0 {
+		return false
+	}
+	delete(t.users, userID)
+	return true
+}
+
+// online / away を切り替える（接続中のユーザーのみ）。状態が変わったら true
+func (t *Tracker) SetAway(userID int, away bool) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	st, ok := t.users[userID]
+	if !ok || st.away == away {
+		return false
+	}
+	st.away = away
+	return true
+}
+
+// 現在の状態
+func (t *Tracker) Status(userID int) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	st, ok := t.users[userID]
+	switch {
+	case !ok:
+		return Offline
+	case st.away:
+		return Away
+	default:
+		return Online
+	}
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Local：ローカルディスクに保存する（コンテナ1台での開発用）
+type Local struct {
+	root string
+}
+
+func NewLocal(root string) *Local {
+	return &Local{root: root}
+}
+
+// キーをディスク上のパスにする（".." などでルートの外に出られないようにする）
+func (l *Local) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if clean == "/" || strings.Contains(key, `\`) {
+		return "", ErrNotFound
+	}
+	return filepath.Join(l.root, filepath.FromSlash(clean)), nil
+}
+
+func (l *Local) Put(key string, r io.Reader, size int64, contentType string) error {
+	dst, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+
+	// 書きかけのファイルが見えないよう、一時ファイルに書いてから置き換える
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // rename 済みなら何もしない
+
+	_, err = io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+func (l *Local) Open(key string) (io.ReadCloser, Object, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, Object{}, err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, Object{}, ErrNotFound
+	} else if err != nil {
+		return nil, Object{}, err
+	}
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		f.Close()
+		return nil, Object{}, ErrNotFound
+	}
+	return f, Object{
+		Size:        info.Size(),
+		ContentType: mime.TypeByExtension(filepath.Ext(p)),
+		ModTime:     info.ModTime(),
+	}, nil
+}
+
+func (l *Local) Delete(key string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return nil
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// ローカルでは直接のURLはないので、サーバーが中継する
+func (l *Local) URL(key string, ttl time.Duration) (string, error) {
+	return "", nil
+}
@@ -0,0 +1,294 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3互換ストレージの設定（パス形式 https://endpoint/bucket/key でアクセスする）
+type S3Config struct {
+	Endpoint       string // 例: http://minio:9000
+	PublicEndpoint string // 期限付きURLに使うエンドポイント（空なら Endpoint）
+	Bucket         string
+	Region         string // 空なら us-east-1
+	AccessKey      string
+	SecretKey      string
+	Presign        bool // true なら期限付きURLへリダイレクトさせる
+}
+
+// S3：S3互換ストレージに保存する（署名は AWS Signature Version 4 を自前で計算）
+type S3 struct {
+	cfg      S3Config
+	endpoint *url.URL
+	public   *url.URL
+	client   *http.Client
+}
+
+const (
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	sigAlgorithm    = "AWS4-HMAC-SHA256"
+	maxPresignTTL   = 7 * 24 * time.Hour // SigV4 の上限
+)
+
+func NewS3(cfg S3Config) (*S3, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" || cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, errors.New("S3_ENDPOINT, S3_BUCKET, S3_ACCESS_KEY and S3_SECRET_KEY are required")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.PublicEndpoint == "" {
+		cfg.PublicEndpoint = cfg.Endpoint
+	}
+	endpoint, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	public, err := url.Parse(strings.TrimSuffix(cfg.PublicEndpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	return &S3{cfg: cfg, endpoint: endpoint, public: public, client: &http.Client{Timeout: 60 * time.Second}}, nil
+}
+
+// バケットがなければ作る（MinIO で立ち上げた直後など）
+func (s *S3) EnsureBucket() error {
+	res, err := s.do(http.MethodHead, "", nil, -1, "")
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		res, err := s.do(http.MethodPut, "", nil, 0, "")
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return s3Error(res)
+		}
+		return nil
+	default:
+		return fmt.Errorf("s3: HEAD bucket %s: %s", s.cfg.Bucket, res.Status)
+	}
+}
+
+func (s *S3) Put(key string, r io.Reader, size int64, contentType string) error {
+	res, err := s.do(http.MethodPut, key, r, size, contentType)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return s3Error(res)
+	}
+	return nil
+}
+
+func (s *S3) Open(key string) (io.ReadCloser, Object, error) {
+	res, err := s.do(http.MethodGet, key, nil, -1, "")
+	if err != nil {
+		return nil, Object{}, err
+	}
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		res.Body.Close()
+		return nil, Object{}, ErrNotFound
+	default:
+		defer res.Body.Close()
+		return nil, Object{}, s3Error(res)
+	}
+	obj := Object{Size: res.ContentLength, ContentType: res.Header.Get("Content-Type")}
+	obj.ModTime, _ = http.ParseTime(res.Header.Get("Last-Modified"))
+	return res.Body, obj, nil
+}
+
+func (s *S3) Delete(key string) error {
+	res, err := s.do(http.MethodDelete, key, nil, -1, "")
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNotFound {
+		return s3Error(res)
+	}
+	return nil
+}
+
+// 期限付きのGET用URL（クエリ文字列に署名を入れる）
+func (s *S3) URL(key string, ttl time.Duration) (string, error) {
+	if !s.cfg.Presign {
+		return "", nil
+	}
+	if ttl <= 0 || ttl > maxPresignTTL {
+		ttl = maxPresignTTL
+	}
+
+	now := time.Now().UTC()
+	u := s.objectURL(s.public, key)
+	scope := s.scope(now)
+
+	q := url.Values{}
+	q.Set("X-Amz-Algorithm", sigAlgorithm)
+	q.Set("X-Amz-Credential", s.cfg.AccessKey+"/"+scope)
+	q.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	q.Set("X-Amz-Expires", strconv.Itoa(int(ttl.Seconds())))
+	q.Set("X-Amz-SignedHeaders", "host")
+
+	canonical := strings.Join([]string{
+		http.MethodGet,
+		u.EscapedPath(),
+		canonicalQuery(q),
+		"host:" + u.Host + "\n",
+		"host",
+		unsignedPayload,
+	}, "\n")
+	q.Set("X-Amz-Signature", s.sign(now, canonical))
+
+	u.RawQuery = canonicalQuery(q)
+	return u.String(), nil
+}
+
+// 署名付きリクエストを送る（size < 0 ならボディなし）
+func (s *S3) do(method, key string, body io.Reader, size int64, contentType string) (*http.Response, error) {
+	u := s.objectURL(s.endpoint, key)
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if size >= 0 && body != nil {
+		req.ContentLength = size
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	now := time.Now().UTC()
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+
+	headers := map[string]string{
+		"host":                 u.Host,
+		"x-amz-content-sha256": unsignedPayload,
+		"x-amz-date":           req.Header.Get("X-Amz-Date"),
+	}
+	if contentType != "" {
+		headers["content-type"] = contentType
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		method,
+		u.EscapedPath(),
+		"", // クエリなし
+		canonicalHeaders.String(),
+		signedHeaders,
+		unsignedPayload,
+	}, "\n")
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigAlgorithm, s.cfg.AccessKey, s.scope(now), signedHeaders, s.sign(now, canonical)))
+
+	return s.client.Do(req)
+}
+
+// バケット（key が空のとき）またはオブジェクトのURL
+func (s *S3) objectURL(base *url.URL, key string) *url.URL {
+	u := *base
+	p := u.Path + "/" + s.cfg.Bucket
+	if key != "" {
+		p += "/" + key
+	}
+	u.Path = p
+	u.RawPath = awsEscape(p, false)
+	return &u
+}
+
+func (s *S3) scope(t time.Time) string {
+	return t.Format("20060102") + "/" + s.cfg.Region + "/s3/aws4_request"
+}
+
+// 正規リクエストから署名を計算する
+func (s *S3) sign(t time.Time, canonicalRequest string) string {
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		sigAlgorithm,
+		t.Format("20060102T150405Z"),
+		s.scope(t),
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), t.Format("20060102"))
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// クエリをキー順に並べ、AWS の規則でエンコードする
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range q[k] {
+			parts = append(parts, awsEscape(k, true)+"="+awsEscape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// RFC 3986 の非予約文字以外をすべて %XX にする（encodeSlash が false なら "/" は残す）
+func awsEscape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// エラーレスポンス（XML）の中身をそのままエラーにする
+func s3Error(res *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+	return fmt.Errorf("s3: %s %s: %s: %s", res.Request.Method, res.Request.URL.Path, res.Status, strings.TrimSpace(string(body)))
+}
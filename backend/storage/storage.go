@@ -0,0 +1,65 @@
+// Package storage は、アップロードされたファイルの保存先を抽象化する。
+// ローカルディスクと S3 互換ストレージ（MinIO など）を同じインターフェースで扱う。
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+// 保存済みオブジェクトの情報
+type Object struct {
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+// Storage：キー（"uploads/abc.jpg" のようなスラッシュ区切りのパス）でファイルを読み書きする
+type Storage interface {
+	// 保存する（同じキーがあれば上書き）
+	Put(key string, r io.Reader, size int64, contentType string) error
+	// 読み出す（なければ ErrNotFound）。返した ReadCloser は呼び出し側で閉じる
+	Open(key string) (io.ReadCloser, Object, error)
+	// 削除する（なくてもエラーにしない）
+	Delete(key string) error
+	// クライアントが直接取りに行ける期限付きURL。未対応なら "" を返す（Open で中継する）
+	URL(key string, ttl time.Duration) (string, error)
+}
+
+// 環境変数から保存先を作る
+//
+//	STORAGE_BACKEND=local（既定）: STORAGE_LOCAL_DIR（既定 "public"）の下に保存
+//	STORAGE_BACKEND=s3: S3_ENDPOINT, S3_BUCKET, S3_REGION, S3_ACCESS_KEY, S3_SECRET_KEY,
+//	                    S3_PUBLIC_ENDPOINT（ブラウザから見たエンドポイント）, S3_PRESIGN（"false" で中継）
+func FromEnv() (Storage, error) {
+	switch os.Getenv("STORAGE_BACKEND") {
+	case "", "local":
+		dir := os.Getenv("STORAGE_LOCAL_DIR")
+		if dir == "" {
+			dir = "public"
+		}
+		return NewLocal(dir), nil
+
+	case "s3":
+		s, err := NewS3(S3Config{
+			Endpoint:       os.Getenv("S3_ENDPOINT"),
+			PublicEndpoint: os.Getenv("S3_PUBLIC_ENDPOINT"),
+			Bucket:         os.Getenv("S3_BUCKET"),
+			Region:         os.Getenv("S3_REGION"),
+			AccessKey:      os.Getenv("S3_ACCESS_KEY"),
+			SecretKey:      os.Getenv("S3_SECRET_KEY"),
+			Presign:        os.Getenv("S3_PRESIGN") != "false",
+		})
+		if err != nil {
+			return nil, err
+		}
+		return s, s.EnsureBucket()
+
+	default:
+		return nil, errors.New("unknown STORAGE_BACKEND: " + os.Getenv("STORAGE_BACKEND"))
+	}
+}
@@ -12,6 +12,17 @@ type Room = {
   unread_count: number;
 };
 
+type Attachment = {
+  id: number;
+  url: string;
+  mime_type: string;
+  size: number;
+  width?: number;
+  height?: number;
+  filename: string;
+  thumbnails?: { size: number; url: string; width: number; height: number }[];
+};
+
 type Message = {
   id: number;
   room_id: number;
@@ -20,6 +31,8 @@ type Message = {
   created_at: string;
   read_by?: number[];
   edited?: boolean;
+  revision?: number;          // 編集回数（古い edit_message を捨てるのに使う）
+  attachments?: Attachment[]; // 添付ファイル
   is_deleted?: boolean;       // 送信取消（物理削除の通知用）
   is_hidden_for?: number[];   // 各ユーザー向け非表示（論理削除）
 };
@@ -40,7 +53,7 @@ export default function ChatRoomPage() {
   const [mentionRooms, setMentionRooms] = useState<number[]>([]);
 
   const messageEndRef = useRef<HTMLDivElement | null>(null);
-  const socketMapRef = useRef<Map<number, WebSocket>>(new Map());
+  const socketRef = useRef<WebSocket | null>(null);
   const roomIdRef = useRef<string | undefined>(undefined);
 
   const [showEmojiPicker, setShowEmojiPicker] = useState(false);
@@ -71,17 +84,18 @@ export default function ChatRoomPage() {
   }, [token]);
 
   useEffect(() => {
-    if (!token || rooms.length === 0) return;
-
-    rooms.forEach((room) => {
-      const roomId = room.room_id;
-      if (socketMapRef.current.has(roomId)) return;
+    if (!token) return;
 
-      const ws = new WebSocket(`ws://localhost:8081/ws?room_id=${roomId}&token=${token}`);
+    // 1本の接続で所属する全ルームのイベントを受け取る
+    const ws = new WebSocket(`ws://localhost:8081/ws?token=${token}`);
 
-      ws.onopen = () => {
-        console.log(`✅ WS OPEN: room ${roomId}`);
-      };
+    ws.onopen = () => {
+      console.log("✅ WS OPEN");
+      // 開いているルームの詳細イベント（既読など）を購読
+      if (roomIdRef.current) {
+        ws.send(JSON.stringify({ type: "subscribe", room_id: parseInt(roomIdRef.current) }));
+      }
+    };
 
       ws.onmessage = (event) => {
         const data = JSON.parse(event.data);
@@ -90,8 +104,8 @@ if (data.type === "edit_message") {
     const edited = data.message as Message;
     setMessages((prev) =>
       prev.map((m) =>
-        m.id === edited.id
-          ? { ...m, content: edited.content, edited: true }
+        m.id === edited.id && (m.revision ?? 0) < (edited.revision ?? 0)
+          ? { ...m, content: edited.content, edited: true, revision: edited.revision }
           : m
       )
     );
@@ -140,13 +154,16 @@ if (data.type === "hide_message") {
               );
             }
           }
-        } else if (data.type === "message_read") {
-          const { message_id, user_id, room_id: readRoomId } = data;
+        } else if (data.type === "mark_read") {
+          // user_id が last_read_message_id までまとめて既読にした
+          const { last_read_message_id, user_id, room_id: readRoomId } = data;
 
           if (String(readRoomId) === roomIdRef.current) {
             setMessages((prev) =>
               prev.map((msg) =>
-                msg.id === message_id && !msg.read_by?.includes(user_id)
+                msg.id <= last_read_message_id &&
+                msg.sender_id !== user_id &&
+                !msg.read_by?.includes(user_id)
                   ? { ...msg, read_by: [...(msg.read_by || []), user_id] }
                   : msg
               )
@@ -178,17 +195,30 @@ if (data.type === "hide_message") {
 
       };
 
-      ws.onclose = () => console.log(`🔌 WS CLOSED: room ${roomId}`);
-      ws.onerror = (e) => console.error("❌ WebSocket error:", e);
+    ws.onclose = () => console.log("🔌 WS CLOSED");
+    ws.onerror = (e) => console.error("❌ WebSocket error:", e);
 
-      socketMapRef.current.set(roomId, ws);
-    });
+    socketRef.current = ws;
+
+    return () => {
+      ws.close();
+      socketRef.current = null;
+    };
+  }, [token]);
+
+  // 表示中のルームだけ詳細イベントを購読する
+  useEffect(() => {
+    const socket = socketRef.current;
+    if (typeof room_id !== "string" || !socket || socket.readyState !== WebSocket.OPEN) return;
 
+    const currentRoomId = parseInt(room_id);
+    socket.send(JSON.stringify({ type: "subscribe", room_id: currentRoomId }));
     return () => {
-      socketMapRef.current.forEach((s) => s.close());
-      socketMapRef.current.clear();
+      if (socket.readyState === WebSocket.OPEN) {
+        socket.send(JSON.stringify({ type: "unsubscribe", room_id: currentRoomId }));
+      }
     };
-  }, [rooms, token]);
+  }, [room_id]);
 
   useEffect(() => {
     if (!token || typeof room_id !== "string") return;
@@ -196,7 +226,7 @@ if (data.type === "hide_message") {
       headers: { Authorization: `Bearer ${token}` },
     })
       .then((res) => res.json())
-      .then((data) => setMessages(Array.isArray(data) ? data : []))
+      .then((data) => setMessages(Array.isArray(data?.messages) ? data.messages : []))
       .catch(() => {
         setMessages([]);
         setError("メッセージの取得に失敗しました");
@@ -210,7 +240,7 @@ if (data.type === "hide_message") {
   useEffect(() => {
     if (!messages.length || !token || !userId) return;
     const currentRoomId = parseInt(room_id as string);
-    const socket = socketMapRef.current.get(currentRoomId);
+    const socket = socketRef.current;
 
     const unreadMessages = messages.filter(
       (msg) =>
@@ -218,17 +248,16 @@ if (data.type === "hide_message") {
         (!msg.read_by || !msg.read_by.includes(userId))
     );
 
-    if (socket && socket.readyState === WebSocket.OPEN) {
-      unreadMessages.forEach((msg) => {
-        socket.send(
-          JSON.stringify({
-            type: "message_read",
-            message_id: msg.id,
-            user_id: userId,
-            room_id: currentRoomId,
-          })
-        );
-      });
+    if (unreadMessages.length && socket && socket.readyState === WebSocket.OPEN) {
+      // 一番新しい未読メッセージまでをまとめて既読にする
+      const lastId = Math.max(...unreadMessages.map((msg) => msg.id));
+      socket.send(
+        JSON.stringify({
+          type: "mark_read",
+          room_id: currentRoomId,
+          message_id: lastId,
+        })
+      );
     }
   }, [messages, token, userId, room_id]);
 
@@ -244,7 +273,7 @@ if (data.type === "hide_message") {
 
   
 
-  const sendMessage = async (content: string) => {
+  const sendMessage = async (content: string, attachmentIds: number[] = []) => {
     try {
       const res = await fetch("http://localhost:8081/messages", {
         method: "POST",
@@ -256,17 +285,15 @@ if (data.type === "hide_message") {
           room_id: parseInt(room_id as string),
           sender_id: userId,
           content,
+          attachment_ids: attachmentIds,
         }),
       });
 
       if (!res.ok) throw new Error();
       const newMsg: Message = await res.json();
 
-      setMessages((prev) => [...prev, newMsg]);
-      const socket = socketMapRef.current.get(parseInt(room_id as string));
-      if (socket && socket.readyState === WebSocket.OPEN) {
-        socket.send(JSON.stringify({ ...newMsg, type: "message" }));
-      }
+      // 他メンバーへの配信はサーバー側で行われる
+      setMessages((prev) => (prev.some((m) => m.id === newMsg.id) ? prev : [...prev, newMsg]));
     } catch {
       setError("送信に失敗しました");
     }
@@ -311,18 +338,18 @@ if (data.type === "hide_message") {
   if (!file) return;
 
   const formData = new FormData();
-  formData.append("image", file);
+  formData.append("file", file);
 
   try {
     const res = await fetch("http://localhost:8081/upload", {
       method: "POST",
+      headers: { Authorization: `Bearer ${token}` },
       body: formData,
     });
+    if (!res.ok) throw new Error();
 
-    const data = await res.json();
-    if (data.url) {
-      await sendMessage(data.url);
-    }
+    const data: Attachment = await res.json();
+    await sendMessage("", [data.id]);
   } catch {
     alert("画像アップロードに失敗しました");
   }
@@ -513,7 +540,9 @@ const handleRecall = async (id: number) => {
       const currentDate = new Date(msg.created_at);
       const previousDate = index > 0 ? new Date(messages[index - 1].created_at) : null;
       const showDateSeparator = !previousDate || currentDate.toDateString() !== previousDate.toDateString();
-      const isImage = msg.content.match(/\.(jpg|jpeg|png|gif)$/i) || msg.content.startsWith("/uploads/");
+      // 添付の画像（以前の形式では本文に /uploads/ のURLが入っている）
+      const imageAttachment = msg.attachments?.find(a => a.mime_type.startsWith("image/"));
+      const isImage = imageAttachment || msg.content.match(/\.(jpg|jpeg|png|gif)$/i) || msg.content.startsWith("/uploads/");
       const readers = (msg.read_by ?? []).filter(id => id !== userId);
       const isMine = msg.sender_id === userId;
       // 自分に非表示のメッセージはスキップ
@@ -589,7 +618,7 @@ if (msg.is_hidden_for?.includes(userId)) return null;
     </div>
   ) : isImage ? (
     <img
-      src={`http://localhost:8081${msg.content}`}
+      src={`http://localhost:8081${imageAttachment?.thumbnails?.find(t => t.size === 480)?.url ?? imageAttachment?.url ?? msg.content}`}
       alt="画像"
       style={{
         maxWidth: "300px",
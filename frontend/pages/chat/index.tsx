@@ -8,6 +8,13 @@ type User = {
   username: string;
 };
 
+type Invitation = {
+  id: number;
+  room_id: number;
+  room_name: string;
+  inviter_name: string | null;
+};
+
 type Room = {
   room_id: number;
   display_name: string;
@@ -25,6 +32,7 @@ export default function ChatHome() {
   const [selectedUserIds, setSelectedUserIds] = useState<number[]>([]);
   const [groupName, setGroupName] = useState("");
   const [rooms, setRooms] = useState<Room[]>([]);
+  const [invitations, setInvitations] = useState<Invitation[]>([]);
   const [showGroupForm, setShowGroupForm] = useState(false);
   const [error, setError] = useState("");
   const [formError, setFormError] = useState("");
@@ -70,10 +78,37 @@ export default function ChatHome() {
       }
     };
 
+    // 自分宛ての承諾待ちの招待
+    const fetchInvitations = async () => {
+      try {
+        const res = await fetch("http://localhost:8081/invitations", {
+          headers: { Authorization: `Bearer ${token}` },
+        });
+        if (res.ok) setInvitations(await res.json());
+      } catch {
+        setError("招待の取得に失敗しました");
+      }
+    };
+
     fetchUsers();
     fetchRooms();
+    fetchInvitations();
   }, [token]);
 
+  // 招待に返事をする（承諾したらそのルームへ移動）
+  const respondInvitation = async (inv: Invitation, accept: boolean) => {
+    const res = await fetch(`http://localhost:8081/invitations/${inv.id}/${accept ? "accept" : "decline"}`, {
+      method: "POST",
+      headers: { Authorization: `Bearer ${token}` },
+    });
+    setInvitations((prev) => prev.filter((i) => i.id !== inv.id));
+    if (!res.ok) {
+      alert("招待への返事に失敗しました：" + (await res.text()));
+      return;
+    }
+    if (accept) router.push(`/chat/${inv.room_id}`);
+  };
+
   const startChat = async (receiverID: number) => {
     try {
       const res = await fetch("http://localhost:8081/start_chat", {
@@ -205,6 +240,21 @@ export default function ChatHome() {
             <button onClick={handleToggleGroupForm} style={{ padding: "0.3rem 0.7rem", backgroundColor: showGroupForm ? "#ccc" : "#f0616d", color: "white", border: "none", borderRadius: "6px", cursor: "pointer" }}>{showGroupForm ? "中止する" : "＋グループ作成"}</button>
           </div>
 
+          {invitations.length > 0 && !showGroupForm && (
+            <div style={{ marginBottom: "1rem", padding: "0.8rem", border: "1px solid #f1dcdc", borderRadius: "8px", backgroundColor: "#fff" }}>
+              <div style={{ fontWeight: 700, color: "#2d3142", marginBottom: "0.5rem" }}>招待</div>
+              {invitations.map((inv) => (
+                <div key={inv.id} style={{ display: "flex", alignItems: "center", justifyContent: "space-between", gap: "0.5rem", padding: "0.3rem 0" }}>
+                  <span>{inv.inviter_name ?? "(退会したユーザー)"} さんから「{inv.room_name}」</span>
+                  <span style={{ display: "flex", gap: "0.3rem" }}>
+                    <button onClick={() => respondInvitation(inv, true)} style={{ padding: "0.3rem 0.7rem", backgroundColor: "#f0616d", color: "white", border: "none", borderRadius: "6px", cursor: "pointer" }}>参加</button>
+                    <button onClick={() => respondInvitation(inv, false)} style={{ padding: "0.3rem 0.7rem", backgroundColor: "#ccc", color: "white", border: "none", borderRadius: "6px", cursor: "pointer" }}>辞退</button>
+                  </span>
+                </div>
+              ))}
+            </div>
+          )}
+
           {showGroupForm ? (
             <div style={{ marginTop: "1rem", padding: "1rem", border: "1px solid #ddd", borderRadius: "8px", backgroundColor: "#fff" }}>
               <input type="text" value={groupName} onChange={(e) => setGroupName(e.target.value)} placeholder="グループ名" style={{ width: "100%", padding: "0.6rem 0.8rem", marginBottom: "0.8rem", borderRadius: "8px", border: "1px solid #ccc", fontSize: "1rem" }} />
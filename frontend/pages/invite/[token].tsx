@@ -0,0 +1,71 @@
+import { useEffect, useState } from "react";
+import { useRouter } from "next/router";
+import { useAuthGuard } from "../../utils/authGuard";
+
+type Preview = {
+  room_id: number;
+  room_name: string;
+  member_count: number;
+  expires_at: string;
+  already_member: boolean;
+};
+
+// 招待リンクのページ：グループを確認して参加する
+export default function InvitePage() {
+  useAuthGuard();
+  const router = useRouter();
+  const { token: inviteToken } = router.query;
+  const token = typeof window !== "undefined" ? localStorage.getItem("token") : null;
+
+  const [preview, setPreview] = useState<Preview | null>(null);
+  const [error, setError] = useState("");
+
+  useEffect(() => {
+    if (!token || typeof inviteToken !== "string") return;
+
+    fetch(`http://localhost:8081/invite_links/${encodeURIComponent(inviteToken)}`, {
+      headers: { Authorization: `Bearer ${token}` },
+    })
+      .then(async (res) => {
+        if (!res.ok) throw new Error(await res.text());
+        setPreview(await res.json());
+      })
+      .catch((e) => setError(e.message || "招待リンクが無効です"));
+  }, [token, inviteToken]);
+
+  const join = async () => {
+    if (typeof inviteToken !== "string") return;
+    const res = await fetch(`http://localhost:8081/invite_links/${encodeURIComponent(inviteToken)}/join`, {
+      method: "POST",
+      headers: { Authorization: `Bearer ${token}` },
+    });
+    if (!res.ok) {
+      setError(await res.text());
+      return;
+    }
+    const data = await res.json();
+    router.push(`/chat/${data.room_id}`);
+  };
+
+  return (
+    <div style={{ display: "flex", justifyContent: "center", alignItems: "center", height: "100vh", fontFamily: "system-ui, sans-serif" }}>
+      <div style={{ padding: "2rem", border: "1px solid #f1dcdc", borderRadius: "12px", backgroundColor: "#fff5f4", minWidth: "320px", textAlign: "center" }}>
+        {error ? (
+          <div style={{ color: "red" }}>{error}</div>
+        ) : !preview ? (
+          <div>読み込み中...</div>
+        ) : (
+          <>
+            <h2 style={{ fontSize: "1.3rem", fontWeight: 700, color: "#2d3142" }}>{preview.room_name}</h2>
+            <p style={{ color: "#666" }}>メンバー {preview.member_count} 人</p>
+            {preview.already_member ? (
+              <button onClick={() => router.push(`/chat/${preview.room_id}`)} style={{ marginTop: "1rem", padding: "0.7rem 1.5rem", backgroundColor: "#f0616d", color: "white", border: "none", borderRadius: "8px", cursor: "pointer", fontWeight: 600 }}>ルームを開く</button>
+            ) : (
+              <button onClick={join} style={{ marginTop: "1rem", padding: "0.7rem 1.5rem", backgroundColor: "#f0616d", color: "white", border: "none", borderRadius: "8px", cursor: "pointer", fontWeight: 600 }}>参加する</button>
+            )}
+          </>
+        )}
+      </div>
+    </div>
+  );
+}
@@ -30,6 +30,7 @@ export default function ProfileSetup() {
     try {
       const res = await fetch('http://localhost:8081/api/profile', {
         method: 'POST',
+        headers: { Authorization: `Bearer ${localStorage.getItem('token')}` },
         body: formData,
       });
 